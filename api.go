@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// apiRequest makes a request to one of the TFE API endpoints that are not
+// supported by the vendored version of go-tfe. If body is not nil it is JSON
+// encoded and used as the request body (unless it's an io.Reader, which is
+// used as is), and if v is not nil the response is JSON decoded into v.
+func (m *Migrator) apiRequest(ctx context.Context, method, path string, body, v interface{}) error {
+	u, err := url.Parse(m.config.Address)
+	if err != nil {
+		return err
+	}
+	u.Path = strings.TrimSuffix(m.config.BasePath, "/") + "/" + strings.TrimPrefix(path, "/")
+
+	// Split off any query parameters from the path.
+	if i := strings.Index(u.Path, "?"); i != -1 {
+		u.RawQuery = u.Path[i+1:]
+		u.Path = u.Path[:i]
+	}
+
+	var r io.Reader
+	switch body := body.(type) {
+	case nil:
+	case io.Reader:
+		r = body
+	default:
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+		r = buf
+	}
+
+	// Create the request.
+	req, err := http.NewRequest(method, u.String(), r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", "application/vnd.api+json")
+	req.Header.Set("Authorization", "Bearer "+m.config.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.api+json")
+	}
+
+	// Make the API call.
+	resp, err := m.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Check the response for any errors.
+	if err := checkAPIResponse(resp); err != nil {
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// retryAfterError is returned when a TFE API call is rate limited or the
+// service is unavailable, and the response tells how long to wait before
+// retrying.
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// checkAPIResponse checks the response of a TFE API call in the same way
+// go-tfe does, so errors can be handled the same way. Unlike go-tfe, the
+// Retry-After header of a rate limited response is returned as well.
+func checkAPIResponse(resp *http.Response) error {
+	err := apiError(resp)
+	if err == nil {
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return &retryAfterError{err: err, wait: wait}
+		}
+	}
+
+	return err
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date, into the time to wait from now.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// apiError returns the error of the response, if any.
+func apiError(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	switch resp.StatusCode {
+	case 401:
+		return tfe.ErrUnauthorized
+	case 404:
+		return tfe.ErrResourceNotFound
+	}
+
+	var response struct {
+		Errors []struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+
+	// Try to parse the error payload, if that fails return the status.
+	err := json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil || len(response.Errors) == 0 {
+		return errors.New(resp.Status)
+	}
+
+	var errs []string
+	for _, e := range response.Errors {
+		if e.Detail == "" {
+			errs = append(errs, e.Title)
+		} else {
+			errs = append(errs, fmt.Sprintf("%s %s", e.Title, e.Detail))
+		}
+	}
+
+	return errors.New(strings.Join(errs, "\n"))
+}
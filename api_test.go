@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Fri, 16 Oct 2026 12:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 16 Oct 2026 11:59:00 GMT", 0, true},
+	}
+
+	for _, c := range cases {
+		wait, ok := parseRetryAfter(c.value, now)
+		if wait != c.wait || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %t, want %v, %t", c.value, wait, ok, c.wait, c.ok)
+		}
+	}
+}
+
+func TestCheckAPIResponse(t *testing.T) {
+	response := func(code int, header http.Header, body string) *http.Response {
+		return &http.Response{
+			StatusCode: code,
+			Status:     http.StatusText(code),
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	if err := checkAPIResponse(response(201, nil, "")); err != nil {
+		t.Errorf("expected no error for a 201, got: %v", err)
+	}
+	if err := checkAPIResponse(response(404, nil, "")); err != tfe.ErrResourceNotFound {
+		t.Errorf("expected %v for a 404, got: %v", tfe.ErrResourceNotFound, err)
+	}
+
+	err := checkAPIResponse(response(422, nil, `{"errors":[{"title":"invalid attribute","detail":"Name has already been taken"}]}`))
+	if err == nil || err.Error() != "invalid attribute Name has already been taken" {
+		t.Errorf("expected the error payload for a 422, got: %v", err)
+	}
+	if isRetryable(err) {
+		t.Errorf("expected a 422 not to be retryable")
+	}
+
+	err = checkAPIResponse(response(429, http.Header{"Retry-After": []string{"7"}}, ""))
+	var retryAfter *retryAfterError
+	if !errors.As(err, &retryAfter) || retryAfter.wait != 7*time.Second {
+		t.Fatalf("expected a retryAfterError waiting 7s for a 429, got: %#v", err)
+	}
+	if !isRetryable(err) {
+		t.Errorf("expected a 429 to be retryable")
+	}
+
+	err = checkAPIResponse(response(429, nil, ""))
+	if errors.As(err, &retryAfter) {
+		t.Errorf("expected a plain error for a 429 without Retry-After, got: %#v", err)
+	}
+	if !isRetryable(err) {
+		t.Errorf("expected a 429 without Retry-After to be retryable")
+	}
+}
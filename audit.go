@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// Possible outcomes of auditing the state of a task.
+const (
+	auditIdentical  = "identical"
+	auditEquivalent = "equivalent"
+	auditDifferent  = "different"
+	auditMissing    = "missing"
+)
+
+// auditState compares the downloaded source state of the task with the
+// current state of its workspace, without changing anything. The states
+// match when they are byte-identical, or when they are equivalent JSON
+// documents that only differ in formatting.
+func (m *Migrator) auditState(ctx context.Context, t *Task) error {
+	w, err := m.auditWorkspace(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	var sv *tfe.StateVersion
+	err = m.retry(ctx, t, "reading current state", func() (err error) {
+		sv, err = m.stateVersions.Current(ctx, w.ID)
+		return err
+	})
+	if err == tfe.ErrResourceNotFound {
+		t.audit = auditMissing
+		return fmt.Errorf(
+			"Workspace has no state (source lineage %s, serial %d)", t.meta.Lineage, t.meta.Serial,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to read the current state version: %v", err)
+	}
+
+	var state []byte
+	err = m.retry(ctx, t, "downloading state", func() (err error) {
+		state, err = m.stateVersions.Download(ctx, sv.DownloadURL)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to download the current state of the workspace: %v", err)
+	}
+
+	r := t.stateReader()
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	source, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(source, state):
+		t.audit = auditIdentical
+	case equivalentJSON(source, state):
+		t.audit = auditEquivalent
+	default:
+		t.audit = auditDifferent
+
+		meta := &Meta{}
+		if err := readMeta(bytes.NewReader(state), meta); err != nil {
+			return fmt.Errorf("Failed to parse the current state of the workspace: %v", err)
+		}
+		return fmt.Errorf(
+			"State differs from the source state (source lineage %s, serial %d; workspace lineage %s, serial %d)",
+			t.meta.Lineage, t.meta.Serial, meta.Lineage, meta.Serial,
+		)
+	}
+
+	t.logger().Info("State matches the source state", "audit", t.audit)
+
+	return nil
+}
+
+// auditWorkspace returns the workspace of the task, which is expected to
+// exist already.
+func (m *Migrator) auditWorkspace(ctx context.Context, t *Task) (*tfe.Workspace, error) {
+	if t.workspaceID != "" {
+		return &tfe.Workspace{ID: t.workspaceID, Name: t.workspace}, nil
+	}
+
+	var w *tfe.Workspace
+	err := m.retry(ctx, t, "reading workspace", func() (err error) {
+		w, err = m.workspaces.Read(ctx, t.organization, t.workspace)
+		return err
+	})
+	if err == tfe.ErrResourceNotFound {
+		t.audit = auditMissing
+		return nil, fmt.Errorf("Workspace %q does not exist in organization %q", t.workspace, t.organization)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read workspace: %v", err)
+	}
+
+	return w, nil
+}
+
+// equivalentJSON reports whether a and b are equal JSON documents, ignoring
+// formatting and the order of object keys.
+func equivalentJSON(a, b []byte) bool {
+	va, err := decodeJSON(a)
+	if err != nil {
+		return false
+	}
+	vb, err := decodeJSON(b)
+	if err != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// decodeJSON decodes a JSON document, keeping numbers as they are written so
+// large serials don't lose precision.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -2,35 +2,67 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 const (
-	commitURL = "%s/rest/api/latest/projects/%s/repos/%s/commits?limit=1"
+	commitURL = "%s/rest/api/latest/projects/%s/repos/%s/commits?limit=1&until=%s"
 	repoURL   = "%s/rest/api/latest/projects/%s/repos/%s/browse/%s?at=%s"
+	rawURL    = "%s/rest/api/latest/projects/%s/repos/%s/raw/%s?at=%s"
+	branchURL = "%s/rest/branch-utils/latest/projects/%s/repos/%s/branches"
+	prURL     = "%s/rest/api/latest/projects/%s/repos/%s/pull-requests"
+	pingURL   = "%s/rest/api/latest/projects?limit=1"
 )
 
-var (
-	bitbucketAddess string
-	bitbucketToken  string
-)
+// bitbucket implements ConfigStore using the Bitbucket Server API.
+type bitbucket struct {
+	address string
+	token   string
+	client  *http.Client
+}
 
-func getLatestCommitID(t *Task) (string, error) {
-	// Compose the URL for the given task..
-	u := fmt.Sprintf(commitURL, bitbucketAddess, t.project, t.repo)
+// Ping implements Pinger.
+func (b *bitbucket) Ping(ctx context.Context) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf(pingURL, b.address), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+b.token)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// LatestCommit implements ConfigStore.
+func (b *bitbucket) LatestCommit(ctx context.Context, t *Task) (string, error) {
+	// Compose the URL for the given task. The commits are scoped to the
+	// branch, so we get the latest commit of the branch we will write to.
+	u := fmt.Sprintf(commitURL, b.address, t.project, t.repo, url.QueryEscape(branchRef(t.branch)))
 
 	// Create the request.
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "Bearer "+bitbucketToken)
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+b.token)
 
 	// Make the API call to receive the latest commit.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := b.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -53,26 +85,49 @@ func getLatestCommitID(t *Task) (string, error) {
 		return "", err
 	}
 
-	if len(commits.Values) != 1 {
-		return "", fmt.Errorf("could not find latest commit")
+	// An empty repository or branch has no commits.
+	if len(commits.Values) == 0 || commits.Values[0].CommitID == "" {
+		return "", fmt.Errorf("no commits found for branch %q", t.branch)
 	}
 
 	return commits.Values[0].CommitID, nil
 }
 
-func readBitbucketFile(t *Task) (string, error) {
+// Read implements ConfigStore. The raw content of the file is read, so the
+// original line endings are preserved. The file is read at the latest commit
+// of the branch, which is recorded in the task, so Write can commit on top of
+// the commit that was read.
+func (b *bitbucket) Read(ctx context.Context, t *Task) (string, error) {
+	commitID, err := b.LatestCommit(ctx, t)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := b.readAt(ctx, t, commitID)
+	if err != nil {
+		return "", err
+	}
+	t.configFileVersion = commitID
+
+	return content, nil
+}
+
+// readAt reads the config file of the task at the given ref, which can be a
+// branch or a commit ID.
+func (b *bitbucket) readAt(ctx context.Context, t *Task, ref string) (string, error) {
 	// Compose the URL for the given task..
-	u := fmt.Sprintf(repoURL, bitbucketAddess, t.project, t.repo, t.configFile, t.branch)
+	u := fmt.Sprintf(rawURL, b.address, t.project, t.repo, t.configFile, url.QueryEscape(ref))
 
 	// Create the request.
 	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return "", err
 	}
-	req.Header.Set("Authorization", "Bearer "+bitbucketToken)
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+b.token)
 
 	// Make the API call to read the file.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := b.client.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -83,35 +138,53 @@ func readBitbucketFile(t *Task) (string, error) {
 		return "", err
 	}
 
-	var content struct {
-		Lines []struct {
-			Text string `json:"text"`
-		} `json:"lines"`
-	}
-
-	// Parse the response to retrieve the file content.
-	err = json.NewDecoder(resp.Body).Decode(&content)
+	content, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return "", err
 	}
 
-	buf := bytes.NewBuffer(nil)
-	for _, line := range content.Lines {
-		buf.WriteString(line.Text + "\n")
+	return string(content), nil
+}
+
+// Write implements ConfigStore. The file is committed on top of the commit it
+// was read at, so Bitbucket rejects the commit when the branch was updated in
+// the meantime, instead of overwriting those changes.
+func (b *bitbucket) Write(ctx context.Context, t *Task, content, message string) error {
+	commitID := t.configFileVersion
+	if commitID == "" {
+		return fmt.Errorf("unknown source commit of %q, the file has to be read first", t.configFile)
 	}
 
-	return buf.String(), nil
-}
+	conflict, err := b.writeFile(ctx, t, content, message, commitID)
+	if !conflict {
+		return err
+	}
 
-func writeBitbucketFile(t *Task, content string) error {
-	// First get the current commit.
-	commitID, err := getLatestCommitID(t)
-	if err != nil {
+	// The branch is out of date, as it moved on after the file was read.
+	// Retry once using the new latest commit, but only when the config
+	// file itself is unchanged, so no changes are overwritten. Otherwise
+	// the config file has to be read and updated again.
+	latestID, lerr := b.LatestCommit(ctx, t)
+	if lerr != nil || latestID == commitID {
 		return err
 	}
+	before, rerr := b.readAt(ctx, t, commitID)
+	after, aerr := b.readAt(ctx, t, latestID)
+	if rerr != nil || aerr != nil || before != after {
+		return fmt.Errorf("%w: %v", errCommitConflict, err)
+	}
+
+	if conflict, err = b.writeFile(ctx, t, content, message, latestID); conflict {
+		return fmt.Errorf("%w: %v", errCommitConflict, err)
+	}
+	return err
+}
 
+// writeFile commits the updated config file on top of the given commit, and
+// reports whether the commit failed because of a conflict.
+func (b *bitbucket) writeFile(ctx context.Context, t *Task, content, message, commitID string) (bool, error) {
 	// Compose the URL for the given task..
-	u := fmt.Sprintf(repoURL, bitbucketAddess, t.project, t.repo, t.configFile, t.branch)
+	u := fmt.Sprintf(repoURL, b.address, t.project, t.repo, t.configFile, url.QueryEscape(branchRef(t.branch)))
 
 	buf := new(bytes.Buffer)
 	mw := multipart.NewWriter(buf)
@@ -120,85 +193,212 @@ func writeBitbucketFile(t *Task, content string) error {
 	// containing the branch, commit ID, message and updated file content.
 	fw, err := mw.CreateFormField("branch")
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Add the branch.
-	if _, err = fw.Write([]byte("refs/heads/" + t.branch)); err != nil {
-		return err
+	// Add the branch. Some Bitbucket versions reject a fully qualified
+	// ref, while all versions accept the plain branch name.
+	if _, err = fw.Write([]byte(branchName(t.branch))); err != nil {
+		return false, err
 	}
 
 	if fw, err = mw.CreateFormField("sourceCommitId"); err != nil {
-		return err
+		return false, err
 	}
 
 	// Add the commit ID.
 	if _, err = fw.Write([]byte(commitID)); err != nil {
-		return err
+		return false, err
 	}
 
 	if fw, err = mw.CreateFormField("message"); err != nil {
-		return err
+		return false, err
 	}
 
 	// Add a custom message.
-	if _, err = fw.Write([]byte("Backend configuration updated by migration tool")); err != nil {
-		return err
+	if _, err = fw.Write([]byte(message)); err != nil {
+		return false, err
 	}
 
 	if fw, err = mw.CreateFormFile("content", "blob"); err != nil {
-		return err
+		return false, err
 	}
 
 	// Add the updated fiel content.
 	if _, err = fw.Write([]byte(content)); err != nil {
-		return err
+		return false, err
 	}
 
 	if err := mw.Close(); err != nil {
-		return err
+		return false, err
 	}
 
 	// Create the request.
 	req, err := http.NewRequest("PUT", u, buf)
 	if err != nil {
-		return err
+		return false, err
 	}
-	req.Header.Set("Authorization", "Bearer "+bitbucketToken)
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+b.token)
 	req.Header.Set("Content-Type", mw.FormDataContentType())
 
 	// Make the API call to write and commit the updated file.
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusConflict, checkResponse(resp)
+}
+
+// CreateBranch implements BranchCreator.
+func (b *bitbucket) CreateBranch(ctx context.Context, t *Task, name string) error {
+	// First get the current commit to branch off from.
+	commitID, err := b.LatestCommit(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	// Create the new branch.
+	u := fmt.Sprintf(branchURL, b.address, t.project, t.repo)
+	branch := map[string]string{
+		"name":       name,
+		"startPoint": commitID,
+	}
+	if err := b.post(ctx, u, branch, nil); err != nil {
+		return fmt.Errorf("error creating branch %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// OpenPullRequest implements PullRequester.
+func (b *bitbucket) OpenPullRequest(ctx context.Context, t *Task, content string, pr *pullRequest) (string, error) {
+	if err := b.CreateBranch(ctx, t, pr.branch); err != nil {
+		return "", err
+	}
+
+	// Commit the updated file to the new branch.
+	bt := *t
+	bt.branch = pr.branch
+	if err := b.Write(ctx, &bt, content, pr.message); err != nil {
+		return "", err
+	}
+
+	ref := func(branch string) map[string]interface{} {
+		return map[string]interface{}{
+			"id": branchRef(branch),
+			"repository": map[string]interface{}{
+				"slug":    t.repo,
+				"project": map[string]string{"key": t.project},
+			},
+		}
+	}
+
+	// Open the pull request against the original branch.
+	u := fmt.Sprintf(prURL, b.address, t.project, t.repo)
+	request := map[string]interface{}{
+		"title":       pr.title,
+		"description": pr.description,
+		"fromRef":     ref(pr.branch),
+		"toRef":       ref(t.branch),
+	}
+
+	var response struct {
+		Links struct {
+			Self []struct {
+				Href string `json:"href"`
+			} `json:"self"`
+		} `json:"links"`
+	}
+	if err := b.post(ctx, u, request, &response); err != nil {
+		return "", fmt.Errorf("error opening pull request: %v", err)
+	}
+
+	if len(response.Links.Self) == 0 {
+		return "", nil
+	}
+
+	return response.Links.Self[0].Href, nil
+}
+
+// post makes a JSON POST request and decodes the response into v, unless v
+// is nil.
+func (b *bitbucket) post(ctx context.Context, u string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	// Create the request.
+	req, err := http.NewRequest("POST", u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	return checkResponse(resp)
+	if err = checkResponse(resp); err != nil {
+		return err
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
 }
 
+// branchName returns the name of the branch, without the refs/heads/ prefix
+// if it's a fully qualified ref. Branches can be given either way.
+func branchName(branch string) string {
+	return strings.TrimPrefix(branch, "refs/heads/")
+}
+
+// branchRef returns the fully qualified ref of the branch.
+func branchRef(branch string) string {
+	return "refs/heads/" + branchName(branch)
+}
+
+// checkResponse returns an error if the response is not successful. The
+// error contains the status, the request and all error messages returned by
+// Bitbucket, if any.
 func checkResponse(resp *http.Response) error {
-	if resp.StatusCode == 200 {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
 		return nil
 	}
 
+	var request string
+	if resp.Request != nil {
+		request = fmt.Sprintf(" (%s %s)", resp.Request.Method, resp.Request.URL.Path)
+	}
+
 	var response struct {
 		Errors []struct {
 			Message string `json:"message"`
 		} `json:"errors"`
 	}
 
-	// If we received an unexpected response code, try to parse the error
-	// in order to get a descriptive error. If that fails, we just return
-	// the received HTTP status instead.
-	err := json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil {
-		return fmt.Errorf("error decoding response: %v", err)
+	// If we received an unexpected response code, try to parse the errors
+	// in order to get a descriptive error. The body may be empty or not
+	// contain JSON at all, in which case only the HTTP status is returned.
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if json.Unmarshal(body, &response) != nil || len(response.Errors) == 0 {
+		return fmt.Errorf("unexpected response%s: %s", request, resp.Status)
 	}
 
-	if len(response.Errors) == 0 {
-		return fmt.Errorf("unexpected response: %s", resp.Status)
+	messages := make([]string, 0, len(response.Errors))
+	for _, e := range response.Errors {
+		messages = append(messages, e.Message)
 	}
 
-	return fmt.Errorf(response.Errors[0].Message)
+	return fmt.Errorf("unexpected response%s: %s: %s", request, resp.Status, strings.Join(messages, "; "))
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Supported Bitbucket flavors.
+const (
+	bitbucketServerFlavor = "server"
+	bitbucketCloudFlavor  = "cloud"
+)
+
+const (
+	bitbucketCloudBranchURL   = "%s/2.0/repositories/%s/%s/refs/branches/%s"
+	bitbucketCloudBranchesURL = "%s/2.0/repositories/%s/%s/refs/branches"
+	bitbucketCloudSrcURL      = "%s/2.0/repositories/%s/%s/src"
+)
+
+// bitbucketCloud implements ConfigStore using the Bitbucket Cloud API. The
+// project of a task is used as the Bitbucket workspace. When a username is
+// set, the token is used as an app password, otherwise as an access token.
+type bitbucketCloud struct {
+	address  string
+	username string
+	token    string
+	client   *http.Client
+}
+
+// bitbucketCloudError is the error returned by the Bitbucket Cloud API.
+type bitbucketCloudError struct {
+	statusCode int
+	status     string
+	message    string
+}
+
+// Error implements error.
+func (e *bitbucketCloudError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("unexpected response: %s", e.status)
+	}
+	return e.message
+}
+
+// LatestCommit implements ConfigStore.
+func (b *bitbucketCloud) LatestCommit(ctx context.Context, t *Task) (string, error) {
+	// Compose the URL for the given task..
+	u := fmt.Sprintf(bitbucketCloudBranchURL, b.address, t.project, t.repo, url.PathEscape(t.branch))
+
+	resp, err := b.do(ctx, "GET", u, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var branch struct {
+		Target struct {
+			Hash string `json:"hash"`
+		} `json:"target"`
+	}
+
+	// Parse the response to retrieve the commit ID.
+	if err := json.NewDecoder(resp.Body).Decode(&branch); err != nil {
+		return "", err
+	}
+
+	if branch.Target.Hash == "" {
+		return "", fmt.Errorf("could not find latest commit")
+	}
+
+	return branch.Target.Hash, nil
+}
+
+// CreateBranch implements BranchCreator.
+func (b *bitbucketCloud) CreateBranch(ctx context.Context, t *Task, name string) error {
+	// First get the current commit to branch off from.
+	commitID, err := b.LatestCommit(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	branch := map[string]interface{}{
+		"name":   name,
+		"target": map[string]string{"hash": commitID},
+	}
+	body, err := json.Marshal(branch)
+	if err != nil {
+		return err
+	}
+
+	// Make the API call to create the new branch.
+	u := fmt.Sprintf(bitbucketCloudBranchesURL, b.address, t.project, t.repo)
+	resp, err := b.do(ctx, "POST", u, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return fmt.Errorf("error creating branch %q: %v", name, err)
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// Read implements ConfigStore. The file is read at the latest commit of the
+// branch, which is recorded in the task, so Write can commit on top of the
+// commit that was read.
+func (b *bitbucketCloud) Read(ctx context.Context, t *Task) (string, error) {
+	commitID, err := b.LatestCommit(ctx, t)
+	if err != nil {
+		return "", err
+	}
+
+	// Compose the URL for the given task..
+	u := fmt.Sprintf(bitbucketCloudSrcURL, b.address, t.project, t.repo) +
+		"/" + url.PathEscape(commitID) + "/" + t.configFile
+
+	// The file content is returned as is.
+	resp, err := b.do(ctx, "GET", u, nil, "")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	t.configFileVersion = commitID
+
+	return string(content), nil
+}
+
+// Write implements ConfigStore. The commit that was read is used as the parent
+// of the new commit, so Bitbucket rejects the commit when the branch was
+// updated in the meantime, instead of overwriting those changes.
+func (b *bitbucketCloud) Write(ctx context.Context, t *Task, content, message string) error {
+	commitID := t.configFileVersion
+	if commitID == "" {
+		return fmt.Errorf("unknown parent commit of %q, the file has to be read first", t.configFile)
+	}
+
+	// Compose the URL for the given task..
+	u := fmt.Sprintf(bitbucketCloudSrcURL, b.address, t.project, t.repo)
+
+	// Files are committed using a form field named after the path of the
+	// file, containing the updated file content.
+	form := url.Values{}
+	form.Set(t.configFile, content)
+	form.Set("branch", t.branch)
+	form.Set("parents", commitID)
+	form.Set("message", message)
+
+	// Make the API call to write and commit the updated file.
+	resp, err := b.do(ctx, "POST", u, strings.NewReader(form.Encode()), "application/x-www-form-urlencoded")
+
+	var berr *bitbucketCloudError
+	if errors.As(err, &berr) && berr.statusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %v", errCommitConflict, err)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	return nil
+}
+
+// do makes a Bitbucket Cloud API call and returns the response if it was
+// successful. The caller is responsible for closing the response body.
+func (b *bitbucketCloud) do(ctx context.Context, method, u string, body io.Reader, contentType string) (*http.Response, error) {
+	// Create the request.
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.token)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Make the API call.
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		var response struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		// Try to parse the error in order to get a descriptive error.
+		json.NewDecoder(resp.Body).Decode(&response)
+
+		return nil, &bitbucketCloudError{
+			statusCode: resp.StatusCode,
+			status:     resp.Status,
+			message:    response.Error.Message,
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeBitbucketCloud is a Bitbucket Cloud hosting the repository ws/repo with
+// a single branch. It serves the config file at each commit, and commits the
+// config file when the head of the branch is one of the parents.
+type fakeBitbucketCloud struct {
+	// The commits of the branch, the latest commit last, and the content
+	// of the config file by commit ID.
+	commits []string
+	files   map[string]string
+}
+
+func (f *fakeBitbucketCloud) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const repo = "/2.0/repositories/ws/repo"
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == repo+"/refs/branches/master":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"target": map[string]string{"hash": f.latest()},
+		})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, repo+"/src/"):
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, repo+"/src/"), "/", 2)
+		content, ok := f.files[parts[0]]
+		if !ok || parts[1] != "main.tf" {
+			writeBitbucketCloudError(w, http.StatusNotFound, "No such file or directory: "+parts[1])
+			return
+		}
+		w.Write([]byte(content))
+	case r.Method == "POST" && r.URL.Path == repo+"/src":
+		if r.FormValue("parents") != f.latest() {
+			writeBitbucketCloudError(w, http.StatusConflict, "Commit is not based on the latest commit of the branch")
+			return
+		}
+
+		id := fmt.Sprintf("c%d", len(f.commits)+1)
+		f.commits = append(f.commits, id)
+		f.files[id] = r.FormValue("main.tf")
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeBitbucketCloudError(w, http.StatusNotFound, "Repository not found")
+	}
+}
+
+// latest returns the ID of the latest commit of the branch.
+func (f *fakeBitbucketCloud) latest() string {
+	return f.commits[len(f.commits)-1]
+}
+
+// writeBitbucketCloudError writes an error response like Bitbucket Cloud does.
+func writeBitbucketCloudError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":  "error",
+		"error": map[string]string{"message": message},
+	})
+}
+
+func TestBitbucketCloudWrite(t *testing.T) {
+	f := &fakeBitbucketCloud{
+		commits: []string{"c1"},
+		files:   map[string]string{"c1": "terraform {}\n"},
+	}
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	b := &bitbucketCloud{address: server.URL, token: "token", client: server.Client()}
+	task := &Task{project: "ws", repo: "repo", branch: "master", configFile: "main.tf"}
+	ctx := context.Background()
+
+	content, err := b.Read(ctx, task)
+	if err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	if content != "terraform {}\n" || task.configFileVersion != "c1" {
+		t.Fatalf("unexpected content %q or commit %q", content, task.configFileVersion)
+	}
+
+	if err := b.Write(ctx, task, "updated\n", "Update backend"); err != nil {
+		t.Fatalf("unexpected error writing the file: %v", err)
+	}
+	if f.latest() != "c2" || f.files["c2"] != "updated\n" {
+		t.Fatalf("expected the config file to be committed, got commits %q", f.commits)
+	}
+
+	// Someone else changes the config file after it was read.
+	stale := &Task{project: "ws", repo: "repo", branch: "master", configFile: "main.tf"}
+	if _, err := b.Read(ctx, stale); err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	f.commits = append(f.commits, "c3")
+	f.files["c3"] = "updated\n# changed\n"
+
+	err = b.Write(ctx, stale, "rewritten\n", "Update backend")
+	if !errors.Is(err, errCommitConflict) {
+		t.Fatalf("expected a commit conflict, got: %v", err)
+	}
+	if f.latest() != "c3" {
+		t.Fatalf("expected the changed file to be left alone, got commits %q", f.commits)
+	}
+}
+
+func TestBitbucketCloudWriteUnread(t *testing.T) {
+	b := &bitbucketCloud{address: "http://127.0.0.1:0", client: http.DefaultClient}
+	task := &Task{project: "ws", repo: "repo", branch: "master", configFile: "main.tf"}
+
+	if err := b.Write(context.Background(), task, "", "Update"); err == nil {
+		t.Fatalf("expected an error writing a file that wasn't read")
+	}
+}
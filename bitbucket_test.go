@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBitbucket is a Bitbucket Server hosting the repository PRJ/repo with a
+// single branch. It serves the commits of the branch and the content of the
+// config file at each commit, and commits the config file when it's written.
+type fakeBitbucket struct {
+	mu sync.Mutex
+
+	// The commits of the branch, the latest commit last, and the content
+	// of the config file by commit ID.
+	commits []string
+	files   map[string]string
+
+	// The raw queries of the requests for the latest commit, and the forms
+	// of the requests committing the config file.
+	queries []string
+	writes  []url.Values
+
+	// Called before committing the config file with the lock held, e.g.
+	// to push a commit to the branch first.
+	beforeWrite func()
+}
+
+func (f *fakeBitbucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	const repo = "/rest/api/latest/projects/PRJ/repos/repo"
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == repo+"/commits":
+		f.queries = append(f.queries, r.URL.RawQuery)
+
+		values := []map[string]string{}
+		if len(f.commits) > 0 {
+			values = append(values, map[string]string{"id": f.latest()})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"values": values})
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, repo+"/raw/"):
+		at := r.URL.Query().Get("at")
+		if strings.HasPrefix(at, "refs/heads/") {
+			at = f.latest()
+		}
+		content, ok := f.files[at]
+		if !ok {
+			bitbucketError(w, http.StatusNotFound, "The path does not exist at revision "+at)
+			return
+		}
+		w.Write([]byte(content))
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, repo+"/browse/"):
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			bitbucketError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.writes = append(f.writes, r.MultipartForm.Value)
+
+		if f.beforeWrite != nil {
+			f.beforeWrite()
+		}
+
+		if r.FormValue("sourceCommitId") != f.latest() {
+			bitbucketError(w, http.StatusConflict, "The file has been modified since the source commit")
+			return
+		}
+
+		file, _, err := r.FormFile("content")
+		if err != nil {
+			bitbucketError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, err := ioutil.ReadAll(file)
+		if err != nil {
+			bitbucketError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id": f.push(string(content))})
+	default:
+		bitbucketError(w, http.StatusNotFound, "Repository does not exist")
+	}
+}
+
+// latest returns the ID of the latest commit of the branch.
+func (f *fakeBitbucket) latest() string {
+	if len(f.commits) == 0 {
+		return ""
+	}
+	return f.commits[len(f.commits)-1]
+}
+
+// push adds a commit with the given content of the config file to the branch,
+// and returns the ID of the commit.
+func (f *fakeBitbucket) push(content string) string {
+	id := fmt.Sprintf("c%d", len(f.commits)+1)
+	f.commits = append(f.commits, id)
+	if f.files == nil {
+		f.files = make(map[string]string)
+	}
+	f.files[id] = content
+	return id
+}
+
+// bitbucketError writes an error response like Bitbucket does.
+func bitbucketError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"message": message}},
+	})
+}
+
+// newFakeBitbucket starts a fake Bitbucket Server and returns a config store
+// using it.
+func newFakeBitbucket(t *testing.T, f *fakeBitbucket) *bitbucket {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	return &bitbucket{address: server.URL, token: "token", client: server.Client()}
+}
+
+func TestBitbucketLatestCommit(t *testing.T) {
+	b := newFakeBitbucket(t, &fakeBitbucket{commits: []string{"c1", "c2"}})
+	task := &Task{project: "PRJ", repo: "repo", branch: "feature"}
+
+	commitID, err := b.LatestCommit(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if commitID != "c2" {
+		t.Fatalf("expected the latest commit c2, got %q", commitID)
+	}
+}
+
+func TestBitbucketLatestCommitEmpty(t *testing.T) {
+	b := newFakeBitbucket(t, &fakeBitbucket{})
+	task := &Task{project: "PRJ", repo: "repo", branch: "feature"}
+
+	_, err := b.LatestCommit(context.Background(), task)
+	if err == nil || err.Error() != `no commits found for branch "feature"` {
+		t.Fatalf("expected no commits to be found, got: %v", err)
+	}
+
+	// Errors of the API are not mistaken for an empty branch.
+	task.project = "NOPE"
+	_, err = b.LatestCommit(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found: Repository does not exist") {
+		t.Fatalf("expected the API error, got: %v", err)
+	}
+}
+
+func TestBitbucketLatestCommitBranch(t *testing.T) {
+	cases := []struct {
+		branch string
+		query  string
+	}{
+		{"master", "limit=1&until=refs%2Fheads%2Fmaster"},
+		{"release/1.0", "limit=1&until=refs%2Fheads%2Frelease%2F1.0"},
+		{"refs/heads/feature", "limit=1&until=refs%2Fheads%2Ffeature"},
+	}
+
+	for _, c := range cases {
+		f := &fakeBitbucket{commits: []string{"c1"}}
+		b := newFakeBitbucket(t, f)
+		task := &Task{project: "PRJ", repo: "repo", branch: c.branch}
+
+		if _, err := b.LatestCommit(context.Background(), task); err != nil {
+			t.Errorf("%s: unexpected error: %v", c.branch, err)
+			continue
+		}
+		if len(f.queries) != 1 || f.queries[0] != c.query {
+			t.Errorf("%s: expected the query %q, got %q", c.branch, c.query, f.queries)
+		}
+	}
+}
+
+func TestBitbucketReadLargeFile(t *testing.T) {
+	// The browse API returns 500 lines per page, so use a file that would
+	// span several pages.
+	var lines []string
+	for i := 0; i < 2500; i++ {
+		lines = append(lines, `resource "null_resource" "r`+strings.Repeat("x", i%10)+`" {}`)
+	}
+	content := "terraform {\n  backend \"s3\" {}\n}\n" + strings.Join(lines, "\n") + "\n"
+
+	b := newFakeBitbucket(t, &fakeBitbucket{
+		commits: []string{"c1"},
+		files:   map[string]string{"c1": content},
+	})
+	task := &Task{project: "PRJ", repo: "repo", branch: "master", configFile: "main.tf"}
+
+	got, err := b.Read(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != content {
+		t.Fatalf("expected all %d bytes of the file, got %d bytes", len(content), len(got))
+	}
+}
+
+func TestBitbucketReadLineEndings(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "CRLF",
+			content: "# main\r\nterraform {\r\n  backend \"s3\" {\r\n    key = \"k\"\r\n  }\r\n}\r\n",
+			want:    "# main\r\nterraform {\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "CRLF without trailing newline",
+			content: "terraform {\r\n  backend \"s3\" {}\r\n}",
+			want:    "terraform {\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}",
+		},
+		{
+			name:    "LF without trailing newline",
+			content: "terraform {\n  backend \"s3\" {}\n}",
+			want:    "terraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}",
+		},
+	}
+
+	for _, c := range cases {
+		b := newFakeBitbucket(t, &fakeBitbucket{
+			commits: []string{"c1"},
+			files:   map[string]string{"c1": c.content},
+		})
+		task := &Task{project: "PRJ", repo: "repo", branch: "master", configFile: "main.tf"}
+
+		content, err := b.Read(context.Background(), task)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if content != c.content {
+			t.Errorf("%s: expected the file to be read as is, got %q", c.name, content)
+			continue
+		}
+
+		updated, _, _, err := replaceBackend(content, testBackend)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if updated != c.want {
+			t.Errorf("%s: unexpected result:\n%q\nwant:\n%q", c.name, updated, c.want)
+		}
+	}
+}
+
+func TestBitbucketWrite(t *testing.T) {
+	f := &fakeBitbucket{
+		commits: []string{"c1"},
+		files:   map[string]string{"c1": "terraform {}\n"},
+	}
+	b := newFakeBitbucket(t, f)
+
+	// The branch is given as a ref, like a branch created for the task.
+	task := &Task{project: "PRJ", repo: "repo", branch: "refs/heads/tfe/app", configFile: "main.tf"}
+
+	if _, err := b.Read(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	if task.configFileVersion != "c1" {
+		t.Fatalf("expected the commit c1 to be recorded, got %q", task.configFileVersion)
+	}
+	if err := b.Write(context.Background(), task, "updated\n", "Update backend"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.latest() != "c2" || f.files["c2"] != "updated\n" {
+		t.Fatalf("expected the config file to be committed, got commits %q", f.commits)
+	}
+
+	// The branch already exists, so only the plain branch name and the
+	// commit to commit on top of are sent.
+	want := url.Values{
+		"branch":         {"tfe/app"},
+		"sourceCommitId": {"c1"},
+		"message":        {"Update backend"},
+	}
+	if len(f.writes) != 1 || fmt.Sprint(f.writes[0]) != fmt.Sprint(want) {
+		t.Fatalf("expected the form %v, got %v", want, f.writes)
+	}
+}
+
+func TestBitbucketWriteStale(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		pushed   string
+		conflict bool
+	}{
+		// Only other files were changed after reading the config file, so
+		// it's committed on top of the latest commit.
+		{"config file unchanged", "terraform {}\n", false},
+		// The config file was changed after reading it, so the commit is
+		// rejected instead of overwriting the change.
+		{"config file changed", "terraform {}\n# changed\n", true},
+	}
+
+	for _, c := range cases {
+		f := &fakeBitbucket{
+			commits: []string{"c1"},
+			files:   map[string]string{"c1": "terraform {}\n"},
+		}
+		b := newFakeBitbucket(t, f)
+		task := &Task{project: "PRJ", repo: "repo", branch: "master", configFile: "main.tf"}
+
+		if _, err := b.Read(ctx, task); err != nil {
+			t.Fatalf("%s: unexpected error reading the file: %v", c.name, err)
+		}
+		f.push(c.pushed)
+
+		err := b.Write(ctx, task, "updated\n", "Update backend")
+		if c.conflict {
+			if !errors.Is(err, errCommitConflict) {
+				t.Errorf("%s: expected a commit conflict, got: %v", c.name, err)
+			}
+			if f.latest() != "c2" || f.files["c2"] != c.pushed {
+				t.Errorf("%s: expected the change to be left alone, got commits %q", c.name, f.commits)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if f.latest() != "c3" || f.files["c3"] != "updated\n" {
+			t.Errorf("%s: expected the config file to be committed on top of c2, got commits %q", c.name, f.commits)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		code int
+		body string
+		err  string
+	}{
+		{http.StatusOK, "", ""},
+		{http.StatusCreated, "", ""},
+		{http.StatusNoContent, "", ""},
+		{http.StatusBadRequest, "", "unexpected response (PUT /browse/main.tf): 400 Bad Request"},
+		{
+			http.StatusBadRequest,
+			`{"errors":[{"message":"first"},{"message":"second"}]}`,
+			"unexpected response (PUT /browse/main.tf): 400 Bad Request: first; second",
+		},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest("PUT", "/browse/main.tf", nil)
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(c.code)
+		rec.WriteString(c.body)
+
+		resp := rec.Result()
+		resp.Request = req
+
+		err := checkResponse(resp)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%d: expected no error, got: %v", c.code, err)
+		case c.err != "" && (err == nil || err.Error() != c.err):
+			t.Errorf("%d: expected error %q, got: %v", c.code, c.err, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// checkpoint records the workspaces that are successfully migrated, one
+// workspace per line, so an interrupted migration can be resumed. Workspaces
+// are recorded by their name prefixed with their organization, as workspace
+// names are only unique within an organization.
+type checkpoint struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// readCheckpoint returns the workspaces recorded in the checkpoint file. If
+// the file does not exist yet, no workspaces are returned.
+func readCheckpoint(path string) (map[string]bool, error) {
+	workspaces := make(map[string]bool)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return workspaces, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if workspace := strings.TrimSpace(scanner.Text()); workspace != "" {
+			workspaces[workspace] = true
+		}
+	}
+
+	return workspaces, scanner.Err()
+}
+
+// skipMigrated returns the tasks of which the workspace is not recorded as
+// migrated.
+func skipMigrated(tasks []*Task, migrated map[string]bool) []*Task {
+	var remaining []*Task
+	for _, t := range tasks {
+		if !migrated[t.qualifiedName()] {
+			remaining = append(remaining, t)
+		}
+	}
+	return remaining
+}
+
+// openCheckpoint opens the checkpoint file for appending, creating it when
+// it does not exist yet.
+func openCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint{f: f}, nil
+}
+
+// add records the workspace, prefixed with its organization, as migrated. It's
+// safe to call concurrently.
+func (c *checkpoint) add(workspace string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintln(c.f, workspace); err != nil {
+		return err
+	}
+
+	// Make sure the workspace is recorded, even if we crash later on.
+	return c.f.Sync()
+}
+
+// Close closes the checkpoint file.
+func (c *checkpoint) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+
+	c, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrated := &Task{organization: "org-a", workspace: "app"}
+	if err := c.add(migrated.qualifiedName()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := readCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A workspace with the same name in another organization is not
+	// skipped.
+	other := &Task{organization: "org-b", workspace: "app"}
+	remaining := skipMigrated([]*Task{migrated, other}, recorded)
+	if len(remaining) != 1 || remaining[0] != other {
+		t.Fatalf("expected only the workspace of org-b to remain, got %d tasks", len(remaining))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// removeSource removes the source state of the task from S3, after it is
+// successfully migrated. When an archive prefix is set, the state is first
+// copied to the same key under the archive prefix.
+func (m *Migrator) removeSource(ctx context.Context, t *Task) error {
+	client := m.s3Clients.client(t.roleARN)
+
+	if m.archivePrefix != "" {
+		key := strings.TrimSuffix(m.archivePrefix, "/") + "/" + t.key
+
+		input := &s3.CopyObjectInput{
+			Bucket:     aws.String(t.bucket),
+			Key:        aws.String(key),
+			CopySource: aws.String(url.PathEscape(t.bucket) + "/" + escapeKey(t.key)),
+		}
+
+		// The encryption of the state is not copied, so make sure a state
+		// encrypted using SSE-KMS is archived using the same key.
+		head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(t.key),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to archive the source state: %v", s3Error(t, err))
+		}
+		if aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms {
+			input.ServerSideEncryption = head.ServerSideEncryption
+			input.SSEKMSKeyId = head.SSEKMSKeyId
+		}
+
+		if _, err := client.CopyObjectWithContext(ctx, input); err != nil {
+			return fmt.Errorf("Failed to archive the source state to s3://%s/%s: %v", t.bucket, key, s3Error(t, err))
+		}
+	}
+
+	_, err := client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(t.key),
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete the source state: %v", s3Error(t, err))
+	}
+
+	return nil
+}
+
+// escapeKey escapes each segment of an S3 key, so it can be used in the copy
+// source of a CopyObject request.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// The go-tfe services are large interfaces of which we only use a few
+// methods, so the migrator depends on these narrow interfaces instead. They
+// are implemented by the services of a tfe.Client, but also make it easy to
+// replace the services with fakes.
+
+// OrganizationService contains the organization operations used by the
+// migrator.
+type OrganizationService interface {
+	// Read an organization by its name.
+	Read(ctx context.Context, organization string) (*tfe.Organization, error)
+}
+
+// WorkspaceService contains the workspace operations used by the migrator.
+type WorkspaceService interface {
+	// Read a workspace by its name.
+	Read(ctx context.Context, organization, workspace string) (*tfe.Workspace, error)
+
+	// Create is used to create a new workspace.
+	Create(ctx context.Context, organization string, options tfe.WorkspaceCreateOptions) (*tfe.Workspace, error)
+}
+
+// StateVersionService contains the state version operations used by the
+// migrator.
+type StateVersionService interface {
+	// Current reads the latest available state from the given workspace.
+	Current(ctx context.Context, workspaceID string) (*tfe.StateVersion, error)
+
+	// Download retrieves the actual stored state of a state version.
+	Download(ctx context.Context, url string) ([]byte, error)
+}
+
+// VariableService contains the variable operations used by the migrator.
+type VariableService interface {
+	// List all the variables associated with the given workspace.
+	List(ctx context.Context, options tfe.VariableListOptions) ([]*tfe.Variable, error)
+
+	// Create is used to create a new variable.
+	Create(ctx context.Context, options tfe.VariableCreateOptions) (*tfe.Variable, error)
+
+	// Update values of an existing variable.
+	Update(ctx context.Context, variableID string, options tfe.VariableUpdateOptions) (*tfe.Variable, error)
+}
+
+// newTFEConfig returns the config of the TFE client. An empty address or token
+// falls back to the TFE_ADDRESS and TFE_TOKEN environment variables. The CA
+// certificates in caFile (if set) are trusted when connecting to TFE, and all
+// requests are sent through the proxy (if set).
+func newTFEConfig(address, basePath, token, caFile string, proxy *url.URL) (*tfe.Config, error) {
+	if address == "" {
+		address = os.Getenv("TFE_ADDRESS")
+	}
+	if address == "" {
+		address = tfe.DefaultAddress
+	}
+	if token == "" {
+		token = os.Getenv("TFE_TOKEN")
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid address %q: missing scheme or host", address)
+	}
+
+	// The path of the address is replaced by the base path in go-tfe, so
+	// any path prefix of the address is moved into the base path instead.
+	basePath = path.Join("/", u.Path, basePath) + "/"
+	u.Path = ""
+
+	transport, err := newTransport(caFile, false, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tfe.Config{
+		Address:    u.String(),
+		BasePath:   basePath,
+		Token:      token,
+		HTTPClient: &http.Client{Transport: transport},
+	}, nil
+}
+
+// tfeHostname returns the hostname of the TFE address in the config.
+func tfeHostname(config *tfe.Config) string {
+	u, err := url.Parse(config.Address)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
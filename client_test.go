@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// fakeTFE is an in-memory TFE. It implements the services used by the
+// migrator, and serves the API endpoints that are called directly.
+type fakeTFE struct {
+	mu sync.Mutex
+
+	// The workspaces by name, and the state versions by workspace ID with
+	// the current state version last.
+	workspaces map[string]*tfe.Workspace
+	states     map[string][]*fakeStateVersion
+
+	// The options of the created workspaces, and the paths of the other
+	// requests that changed a workspace.
+	created []tfe.WorkspaceCreateOptions
+	updates []string
+
+	// Called before creating a workspace with the lock held, e.g. to have
+	// it created concurrently.
+	beforeCreate func()
+
+	// Errors returned by the services, and the status returned when
+	// uploading a state, if set.
+	readErr    error
+	createErr  error
+	currentErr error
+	uploadCode int
+
+	// The number of uploaded states that are stored, but respond with a
+	// server error, as if the response got lost.
+	lostUploads int
+}
+
+// fakeStateVersion is a state version stored by the fake TFE.
+type fakeStateVersion struct {
+	serial  int64
+	lineage string
+	md5     string
+	state   []byte
+}
+
+func (f *fakeTFE) readWorkspace(organization, workspace string) (*tfe.Workspace, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.readErr != nil {
+		return nil, f.readErr
+	}
+	if w, ok := f.workspaces[workspace]; ok {
+		return w, nil
+	}
+	return nil, tfe.ErrResourceNotFound
+}
+
+func (f *fakeTFE) createWorkspace(organization string, options tfe.WorkspaceCreateOptions) (*tfe.Workspace, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.beforeCreate != nil {
+		f.beforeCreate()
+	}
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	if _, ok := f.workspaces[*options.Name]; ok {
+		return nil, errors.New("Invalid Attribute\nName has already been taken")
+	}
+	if f.workspaces == nil {
+		f.workspaces = make(map[string]*tfe.Workspace)
+	}
+
+	w := &tfe.Workspace{ID: "ws-" + *options.Name, Name: *options.Name}
+	f.workspaces[w.Name] = w
+	f.created = append(f.created, options)
+
+	return w, nil
+}
+
+func (f *fakeTFE) current(workspaceID string) (*tfe.StateVersion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.currentErr != nil {
+		return nil, f.currentErr
+	}
+	states := f.states[workspaceID]
+	if len(states) == 0 {
+		return nil, tfe.ErrResourceNotFound
+	}
+
+	sv := states[len(states)-1]
+	return &tfe.StateVersion{
+		ID:          fmt.Sprintf("sv-%d", sv.serial),
+		DownloadURL: fmt.Sprintf("%s/%d", workspaceID, len(states)-1),
+		Serial:      sv.serial,
+	}, nil
+}
+
+func (f *fakeTFE) download(url string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The download URL is the workspace ID and the index of the state
+	// version.
+	parts := strings.Split(url, "/")
+	if len(parts) != 2 {
+		return nil, tfe.ErrResourceNotFound
+	}
+	i, err := strconv.Atoi(parts[1])
+	if err != nil || i >= len(f.states[parts[0]]) {
+		return nil, tfe.ErrResourceNotFound
+	}
+	return f.states[parts[0]][i].state, nil
+}
+
+// ServeHTTP serves the API endpoints that are called directly.
+func (f *fakeTFE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v2/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case r.Method == "POST" && len(parts) == 3 && parts[0] == "workspaces" && parts[2] == "state-versions":
+		if f.uploadCode != 0 {
+			tfeError(w, f.uploadCode, "upload failed")
+			return
+		}
+
+		var payload struct {
+			Data struct {
+				Attributes struct {
+					Serial  int64  `json:"serial"`
+					Lineage string `json:"lineage"`
+					MD5     string `json:"md5"`
+					State   string `json:"state"`
+				} `json:"attributes"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			tfeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		state, err := base64.StdEncoding.DecodeString(payload.Data.Attributes.State)
+		if err != nil {
+			tfeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if f.states == nil {
+			f.states = make(map[string][]*fakeStateVersion)
+		}
+		f.states[parts[1]] = append(f.states[parts[1]], &fakeStateVersion{
+			serial:  payload.Data.Attributes.Serial,
+			lineage: payload.Data.Attributes.Lineage,
+			md5:     payload.Data.Attributes.MD5,
+			state:   state,
+		})
+		if f.lostUploads > 0 {
+			f.lostUploads--
+			tfeError(w, http.StatusBadGateway, "Bad Gateway")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PATCH" && len(parts) == 2 && parts[0] == "workspaces":
+		f.updates = append(f.updates, path)
+	default:
+		tfeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// tfeError writes an error response like TFE does.
+func tfeError(w http.ResponseWriter, code int, title string) {
+	w.Header().Set("Content-Type", "application/vnd.api+json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"title": title}},
+	})
+}
+
+// fakeWorkspaces implements WorkspaceService using the fake TFE.
+type fakeWorkspaces struct {
+	*fakeTFE
+}
+
+func (f fakeWorkspaces) Read(ctx context.Context, organization, workspace string) (*tfe.Workspace, error) {
+	return f.readWorkspace(organization, workspace)
+}
+
+func (f fakeWorkspaces) Create(ctx context.Context, organization string, options tfe.WorkspaceCreateOptions) (*tfe.Workspace, error) {
+	return f.createWorkspace(organization, options)
+}
+
+// fakeStateVersions implements StateVersionService using the fake TFE.
+type fakeStateVersions struct {
+	*fakeTFE
+}
+
+func (f fakeStateVersions) Current(ctx context.Context, workspaceID string) (*tfe.StateVersion, error) {
+	return f.current(workspaceID)
+}
+
+func (f fakeStateVersions) Download(ctx context.Context, url string) ([]byte, error) {
+	return f.download(url)
+}
+
+// newFakeTFE starts the API of the fake TFE and returns a migrator using it.
+// Failed calls are not retried.
+func newFakeTFE(t *testing.T, f *fakeTFE) *Migrator {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	return &Migrator{
+		workspaces:    fakeWorkspaces{f},
+		stateVersions: fakeStateVersions{f},
+		config: &tfe.Config{
+			Address:    server.URL,
+			BasePath:   "/api/v2/",
+			Token:      "token",
+			HTTPClient: server.Client(),
+		},
+	}
+}
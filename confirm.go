@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// summarize writes a summary of the changes the migration of the tasks will
+// make, so they can be confirmed before starting.
+func (m *Migrator) summarize(w io.Writer, tasks []*Task) {
+	fmt.Fprintln(w, "The migration will make the following changes:")
+
+	if !m.backendUpdateOnly {
+		workspaces := make(map[string]int)
+		var organizations []string
+		for _, t := range tasks {
+			if workspaces[t.organization] == 0 {
+				organizations = append(organizations, t.organization)
+			}
+			workspaces[t.organization]++
+		}
+		sort.Strings(organizations)
+
+		for _, organization := range organizations {
+			fmt.Fprintf(w, "  Migrate %d states to workspaces in organization %q\n",
+				workspaces[organization], organization)
+		}
+	}
+
+	if !m.noBackendUpdate {
+		how := "committing them directly"
+		switch {
+		case m.openPR:
+			how = "opening pull requests"
+		case m.branchTemplate != nil:
+			how = "committing them to new branches"
+		}
+		fmt.Fprintf(w, "  Rewrite the backend configuration of %d config files, %s\n", len(tasks), how)
+	}
+
+	if m.triggerPlan {
+		fmt.Fprintf(w, "  Queue a plan-only run in %d workspaces\n", len(tasks))
+	}
+
+	if m.deleteSource {
+		if m.archivePrefix != "" {
+			fmt.Fprintf(w, "  Move %d source states to the archive prefix %q\n", len(tasks), m.archivePrefix)
+		} else {
+			fmt.Fprintf(w, "  Delete %d source states\n", len(tasks))
+		}
+	}
+}
+
+// confirm asks to confirm the changes and reports whether "yes" is answered,
+// in the same way as Terraform asks to confirm an apply.
+func confirm(r io.Reader, w io.Writer) bool {
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Do you want to perform these actions?")
+	fmt.Fprintln(w, "  Only 'yes' will be accepted to approve.")
+	fmt.Fprintln(w)
+	fmt.Fprint(w, "  Enter a value: ")
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	fmt.Fprintln(w)
+
+	return strings.TrimSpace(answer) == "yes"
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// The suffix of the state files that are discovered.
+	stateSuffix = ".tfstate"
+
+	// The default template used to derive a workspace name from a key.
+	defaultWorkspaceTemplate = `{{replace .Path "/" "-"}}`
+)
+
+// workspaceName contains the values available in a workspace name template.
+type workspaceName struct {
+	// The full key of the state.
+	Key string
+
+	// The prefix used to discover the state.
+	Prefix string
+
+	// The key relative to the prefix, without the .tfstate suffix.
+	Path string
+
+	// The directory and the base name of the path.
+	Dir  string
+	Name string
+
+	// The segments of the path, e.g. {{index .Segments 0}} is the
+	// first directory of the path.
+	Segments []string
+}
+
+// isPrefix reports whether the key of a task is a prefix, in which case the
+// task is expanded into a task for every state found under the prefix.
+func isPrefix(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
+
+// parseWorkspaceTemplate parses a template used to derive workspace names.
+func parseWorkspaceTemplate(text string) (*template.Template, error) {
+	return template.New("workspace").Funcs(template.FuncMap{
+		"replace": strings.ReplaceAll,
+		"lower":   strings.ToLower,
+	}).Option("missingkey=error").Parse(text)
+}
+
+// deriveWorkspace derives the workspace name of the state with the given key
+// using the template. The prefix is the prefix used to discover the state, if
+// any.
+func deriveWorkspace(tmpl *template.Template, key, prefix string) (string, error) {
+	p := strings.TrimSuffix(strings.TrimPrefix(key, prefix), stateSuffix)
+	name := workspaceName{
+		Key:      key,
+		Prefix:   prefix,
+		Path:     p,
+		Dir:      path.Dir(p),
+		Name:     path.Base(p),
+		Segments: strings.Split(p, "/"),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, name); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// defaultWorkspaceKeyPrefix is the default prefix of the keys of the states
+// of non-default workspaces in the s3 backend.
+const defaultWorkspaceKeyPrefix = "env:"
+
+// deriveBackendWorkspaces derives the workspace name of every task that has
+// to derive it from its backend. The name is derived from the key configured
+// in the s3 backend of the config file of the task, or from the key of the
+// state itself when it's the state of a non-default workspace of that backend
+// (stored as <workspace_key_prefix>/<workspace>/<key>).
+func (m *Migrator) deriveBackendWorkspaces(ctx context.Context, tasks []*Task) error {
+	var errs []string
+
+	for _, t := range tasks {
+		if !t.workspaceFromBackend || t.workspace != "" {
+			continue
+		}
+
+		key, err := m.backendKey(ctx, t)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("record %d: %v", t.record, err))
+			continue
+		}
+
+		if t.workspace, err = deriveWorkspace(m.workspaceTemplate, key, ""); err != nil {
+			errs = append(errs, fmt.Sprintf("record %d: Failed to derive workspace name for %q: %v", t.record, key, err))
+			continue
+		}
+		if m.nameReplace != "" {
+			t.workspace = normalizeWorkspaceName(t.workspace, m.nameReplace)
+		}
+		t.logger().Debug("Derived workspace name from backend", "backend_key", key)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Found %d errors:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// backendKey returns the key of the state of the task according to the s3
+// backend in the config file of the task.
+func (m *Migrator) backendKey(ctx context.Context, t *Task) (string, error) {
+	store, ok := m.stores[t.vcs]
+	if !ok {
+		return "", fmt.Errorf("Unsupported VCS provider %q", t.vcs)
+	}
+
+	content, err := m.readConfigFile(ctx, t, store)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read config file %q from %s: %v", t.configFile, t.vcs, err)
+	}
+
+	backend, err := s3Backend(content)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse config file %q: %v", t.configFile, err)
+	}
+	if backend["key"] == "" {
+		return "", fmt.Errorf("No s3 backend with a literal key found in %q", t.configFile)
+	}
+
+	prefix, ok := backend["workspace_key_prefix"]
+	if !ok {
+		prefix = defaultWorkspaceKeyPrefix
+	}
+	if strings.HasPrefix(t.key, prefix+"/") && strings.HasSuffix(t.key, "/"+backend["key"]) {
+		return t.key, nil
+	}
+
+	return backend["key"], nil
+}
+
+// expandPrefixes replaces every task that has a key prefix by a task for each
+// state found under that prefix. The workspace of a prefix task is used as
+// the template to derive the workspace names, or the default template if the
+// task has no workspace.
+func (m *Migrator) expandPrefixes(ctx context.Context, tasks []*Task) ([]*Task, error) {
+	var expanded []*Task
+	seen := make(map[string]bool)
+
+	for _, t := range tasks {
+		if !isPrefix(t.key) {
+			expanded = append(expanded, t)
+			seen[t.qualifiedName()] = true
+			continue
+		}
+
+		if t.source != s3Source {
+			return nil, fmt.Errorf("Key prefixes are only supported for S3 buckets, got %s://%s", t.source, t.bucket)
+		}
+
+		tmpl := m.workspaceTemplate
+		if t.workspace != "" {
+			var err error
+			if tmpl, err = parseWorkspaceTemplate(t.workspace); err != nil {
+				return nil, fmt.Errorf("Invalid workspace template %q: %v", t.workspace, err)
+			}
+		}
+
+		prefix := strings.TrimPrefix(t.key, "/")
+		keys, err := m.listStates(ctx, t.roleARN, t.bucket, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list states in s3://%s/%s: %v", t.bucket, prefix, err)
+		}
+
+		for _, key := range keys {
+			workspace, err := deriveWorkspace(tmpl, key, prefix)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to derive workspace name for %q: %v", key, err)
+			}
+			if m.nameReplace != "" {
+				workspace = normalizeWorkspaceName(workspace, m.nameReplace)
+			}
+			if !validWorkspaceName(workspace) {
+				return nil, fmt.Errorf("Invalid workspace name %q derived for %q", workspace, key)
+			}
+
+			task := *t
+			task.key = key
+			task.workspace = workspace
+			task.meta = &Meta{}
+
+			if seen[task.qualifiedName()] {
+				return nil, fmt.Errorf("Duplicate workspace name %q derived for %q", workspace, key)
+			}
+			seen[task.qualifiedName()] = true
+
+			expanded = append(expanded, &task)
+		}
+	}
+
+	return expanded, nil
+}
+
+// listStates returns the keys of all state files under the prefix, using the
+// given role to access the bucket.
+func (m *Migrator) listStates(ctx context.Context, roleARN, bucket, prefix string) ([]string, error) {
+	var keys []string
+
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	// The objects are returned in pages of (at most) 1000 objects.
+	err := m.s3Clients.client(roleARN).ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, object := range page.Contents {
+			if key := aws.StringValue(object.Key); strings.HasSuffix(key, stateSuffix) {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pattern matches workspace names.
+type pattern func(name string) bool
+
+// parsePatterns parses a comma separated list of workspace name patterns. A
+// pattern enclosed in slashes is a regular expression, all other patterns are
+// glob patterns (e.g. app-*).
+func parsePatterns(s string) ([]pattern, error) {
+	var patterns []pattern
+
+	for _, p := range parseList(s) {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+			}
+			patterns = append(patterns, re.MatchString)
+			continue
+		}
+
+		// Check the glob pattern now, as path.Match only reports a bad
+		// pattern when it's matched against a name.
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", p, err)
+		}
+		glob := p
+		patterns = append(patterns, func(name string) bool {
+			matched, _ := path.Match(glob, name)
+			return matched
+		})
+	}
+
+	return patterns, nil
+}
+
+// matchAny reports whether the name matches any of the patterns.
+func matchAny(patterns []pattern, name string) bool {
+	for _, p := range patterns {
+		if p(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTasks returns the tasks with a workspace name that matches any of the
+// include patterns (if any) and none of the exclude patterns.
+func filterTasks(tasks []*Task, include, exclude []pattern) []*Task {
+	var filtered []*Task
+	for _, t := range tasks {
+		if len(include) > 0 && !matchAny(include, t.workspace) {
+			continue
+		}
+		if matchAny(exclude, t.workspace) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered
+}
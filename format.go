@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// formatConfig formats the configuration using terraform fmt, which has to be
+// in the PATH. The line endings of the configuration are preserved.
+func formatConfig(ctx context.Context, content string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "terraform", "fmt", "-no-color", "-")
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+
+	formatted := stdout.String()
+	if strings.Contains(content, "\r\n") {
+		formatted = strings.Replace(strings.Replace(formatted, "\r\n", "\n", -1), "\n", "\r\n", -1)
+	}
+
+	return formatted, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	githubCommitURL   = "%s/repos/%s/%s/commits/%s"
+	githubContentsURL = "%s/repos/%s/%s/contents/%s"
+	githubRefsURL     = "%s/repos/%s/%s/git/refs"
+	githubUserURL     = "%s/user"
+)
+
+// github implements ConfigStore using the GitHub Contents API. The project
+// of a task is used as the owner of the repository.
+type github struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// githubFile represents a file returned by the Contents API.
+type githubFile struct {
+	SHA      string `json:"sha"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+// githubError is the error returned by the GitHub API.
+type githubError struct {
+	statusCode int
+	status     string
+	Message    string `json:"message"`
+}
+
+// Error implements error.
+func (e *githubError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("unexpected response: %s", e.status)
+	}
+	return e.Message
+}
+
+// Ping implements Pinger.
+func (g *github) Ping(ctx context.Context) error {
+	return g.do(ctx, "GET", fmt.Sprintf(githubUserURL, g.address), nil, nil)
+}
+
+// LatestCommit implements ConfigStore.
+func (g *github) LatestCommit(ctx context.Context, t *Task) (string, error) {
+	// Compose the URL for the given task..
+	u := fmt.Sprintf(githubCommitURL, g.address, t.project, t.repo, url.PathEscape(t.branch))
+
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+
+	// Make the API call to receive the latest commit.
+	if err := g.do(ctx, "GET", u, nil, &commit); err != nil {
+		return "", err
+	}
+
+	if commit.SHA == "" {
+		return "", fmt.Errorf("could not find latest commit")
+	}
+
+	return commit.SHA, nil
+}
+
+// CreateBranch implements BranchCreator.
+func (g *github) CreateBranch(ctx context.Context, t *Task, name string) error {
+	// First get the current commit to branch off from.
+	commitID, err := g.LatestCommit(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	ref := map[string]string{
+		"ref": "refs/heads/" + name,
+		"sha": commitID,
+	}
+
+	// Make the API call to create the new branch.
+	u := fmt.Sprintf(githubRefsURL, g.address, t.project, t.repo)
+	if err := g.do(ctx, "POST", u, ref, nil); err != nil {
+		return fmt.Errorf("error creating branch %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// Read implements ConfigStore. The blob SHA of the file is recorded in the
+// task, so Write can detect changes made after reading it.
+func (g *github) Read(ctx context.Context, t *Task) (string, error) {
+	file, err := g.readFile(ctx, t)
+	if err != nil {
+		return "", err
+	}
+	t.configFileVersion = file.SHA
+
+	if file.Encoding != "base64" {
+		return "", fmt.Errorf("unexpected file encoding: %s", file.Encoding)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// Write implements ConfigStore. The file is updated using the blob SHA of the
+// file that was read, so GitHub rejects the commit when the file was changed
+// in the meantime, instead of overwriting those changes.
+func (g *github) Write(ctx context.Context, t *Task, content, message string) error {
+	if t.configFileVersion == "" {
+		return fmt.Errorf("unknown blob SHA of %q, the file has to be read first", t.configFile)
+	}
+
+	options := struct {
+		Message string `json:"message"`
+		Content string `json:"content"`
+		SHA     string `json:"sha"`
+		Branch  string `json:"branch"`
+	}{
+		Message: message,
+		Content: base64.StdEncoding.EncodeToString([]byte(content)),
+		SHA:     t.configFileVersion,
+		Branch:  t.branch,
+	}
+
+	// Make the API call to write and commit the updated file.
+	err := g.do(ctx, "PUT", g.contentsURL(t), options, nil)
+
+	var gerr *githubError
+	if errors.As(err, &gerr) && gerr.statusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %v", errCommitConflict, err)
+	}
+	return err
+}
+
+// readFile reads the config file of the task.
+func (g *github) readFile(ctx context.Context, t *Task) (*githubFile, error) {
+	u := g.contentsURL(t) + "?ref=" + url.QueryEscape(t.branch)
+
+	file := &githubFile{}
+	if err := g.do(ctx, "GET", u, nil, file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// contentsURL returns the Contents API URL of the config file of the task. Each
+// segment of the path is escaped, as the path is part of the URL path.
+func (g *github) contentsURL(t *Task) string {
+	segments := strings.Split(t.configFile, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf(githubContentsURL, g.address, t.project, t.repo, strings.Join(segments, "/"))
+}
+
+// do makes a GitHub API call. If body is not nil, it's JSON encoded and used
+// as the request body. If v is not nil, the response is decoded into v.
+func (g *github) do(ctx context.Context, method, u string, body, v interface{}) error {
+	buf := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	// Create the request.
+	req, err := http.NewRequest(method, u, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+g.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Make the API call.
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &githubError{statusCode: resp.StatusCode, status: resp.Status}
+
+		// Try to parse the error in order to get a descriptive error.
+		json.NewDecoder(resp.Body).Decode(e)
+
+		return e
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeGitHub serves a single file using the Contents API. The file has to be
+// written using its current blob SHA, like GitHub requires.
+type fakeGitHub struct {
+	sha     string
+	content string
+	writes  int
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/repos/owner/repo/contents/main.tf" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(&githubFile{
+			SHA:      f.sha,
+			Content:  base64.StdEncoding.EncodeToString([]byte(f.content)),
+			Encoding: "base64",
+		})
+	case "PUT":
+		var options struct {
+			Content string `json:"content"`
+			SHA     string `json:"sha"`
+		}
+		json.NewDecoder(r.Body).Decode(&options)
+
+		if options.SHA != f.sha {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"message": "main.tf does not match " + options.SHA})
+			return
+		}
+
+		content, _ := base64.StdEncoding.DecodeString(options.Content)
+		f.content = string(content)
+		f.sha += "+"
+		f.writes++
+	}
+}
+
+func TestGitHubWrite(t *testing.T) {
+	fake := &fakeGitHub{sha: "a1", content: "terraform {}\n"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	g := &github{address: server.URL, client: server.Client()}
+	task := &Task{project: "owner", repo: "repo", branch: "master", configFile: "main.tf"}
+	ctx := context.Background()
+
+	content, err := g.Read(ctx, task)
+	if err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	if content != "terraform {}\n" || task.configFileVersion != "a1" {
+		t.Fatalf("unexpected content %q or blob SHA %q", content, task.configFileVersion)
+	}
+
+	if err := g.Write(ctx, task, "terraform { backend \"remote\" {} }\n", "Update"); err != nil {
+		t.Fatalf("unexpected error writing the file: %v", err)
+	}
+	if fake.writes != 1 || fake.content != "terraform { backend \"remote\" {} }\n" {
+		t.Fatalf("expected the file to be written once, got %d writes and content %q", fake.writes, fake.content)
+	}
+
+	// Someone else changes the file after it was read.
+	stale := &Task{project: "owner", repo: "repo", branch: "master", configFile: "main.tf"}
+	if _, err := g.Read(ctx, stale); err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	fake.content, fake.sha = "terraform {}\n# changed\n", "b2"
+
+	err = g.Write(ctx, stale, "terraform { backend \"remote\" {} }\n", "Update")
+	if !errors.Is(err, errCommitConflict) {
+		t.Fatalf("expected a commit conflict, got: %v", err)
+	}
+	if fake.writes != 1 || fake.content != "terraform {}\n# changed\n" {
+		t.Fatalf("expected the changed file to be left alone, got %d writes and content %q", fake.writes, fake.content)
+	}
+}
+
+func TestGitHubWriteUnread(t *testing.T) {
+	g := &github{address: "http://127.0.0.1:0", client: http.DefaultClient}
+	task := &Task{project: "owner", repo: "repo", branch: "master", configFile: "main.tf"}
+
+	if err := g.Write(context.Background(), task, "", "Update"); err == nil {
+		t.Fatalf("expected an error writing a file that wasn't read")
+	}
+}
+
+func TestGitHubContentsURL(t *testing.T) {
+	g := &github{address: "https://api.github.com"}
+
+	cases := []struct {
+		configFile string
+		want       string
+	}{
+		{"main.tf", "https://api.github.com/repos/owner/repo/contents/main.tf"},
+		{"envs/prod/main.tf", "https://api.github.com/repos/owner/repo/contents/envs/prod/main.tf"},
+		{"envs/prod #1/main?.tf", "https://api.github.com/repos/owner/repo/contents/envs/prod%20%231/main%3F.tf"},
+	}
+
+	for _, c := range cases {
+		task := &Task{project: "owner", repo: "repo", configFile: c.configFile}
+		if got := g.contentsURL(task); got != c.want {
+			t.Errorf("%s: contentsURL() = %q, want %q", c.configFile, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	gitlabBranchURL   = "%s/api/v4/projects/%s/repository/branches/%s"
+	gitlabBranchesURL = "%s/api/v4/projects/%s/repository/branches"
+	gitlabFileURL     = "%s/api/v4/projects/%s/repository/files/%s"
+	gitlabUserURL     = "%s/api/v4/user"
+)
+
+// gitlab implements ConfigStore using the GitLab Repository Files API. The
+// project and repo of a task are combined into the path of the project.
+type gitlab struct {
+	address string
+	token   string
+	client  *http.Client
+}
+
+// gitlabError is the error returned by the GitLab API.
+type gitlabError struct {
+	statusCode int
+	status     string
+
+	// The message can either be a string or an object with field errors.
+	Message interface{} `json:"message"`
+}
+
+// Error implements error.
+func (e *gitlabError) Error() string {
+	switch msg := e.Message.(type) {
+	case nil:
+		return fmt.Sprintf("unexpected response: %s", e.status)
+	case string:
+		return msg
+	default:
+		return fmt.Sprintf("%v", msg)
+	}
+}
+
+// conflict reports whether the error is caused by committing a file that was
+// changed after its last known commit. GitLab responds with a 400 Bad Request
+// in that case, so the message is checked as well.
+func (e *gitlabError) conflict() bool {
+	if e.statusCode == http.StatusConflict {
+		return true
+	}
+	msg, _ := e.Message.(string)
+	return e.statusCode == http.StatusBadRequest && strings.Contains(msg, "has changed since")
+}
+
+// Ping implements Pinger.
+func (g *gitlab) Ping(ctx context.Context) error {
+	return g.do(ctx, "GET", fmt.Sprintf(gitlabUserURL, g.address), nil, nil)
+}
+
+// LatestCommit implements ConfigStore.
+func (g *gitlab) LatestCommit(ctx context.Context, t *Task) (string, error) {
+	// Compose the URL for the given task..
+	u := fmt.Sprintf(gitlabBranchURL, g.address, g.projectID(t), escapePath(t.branch))
+
+	var branch struct {
+		Commit struct {
+			ID string `json:"id"`
+		} `json:"commit"`
+	}
+
+	// Make the API call to receive the latest commit.
+	if err := g.do(ctx, "GET", u, nil, &branch); err != nil {
+		return "", err
+	}
+
+	if branch.Commit.ID == "" {
+		return "", fmt.Errorf("could not find latest commit")
+	}
+
+	return branch.Commit.ID, nil
+}
+
+// CreateBranch implements BranchCreator.
+func (g *gitlab) CreateBranch(ctx context.Context, t *Task, name string) error {
+	// First get the current commit to branch off from.
+	commitID, err := g.LatestCommit(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	options := struct {
+		Branch string `json:"branch"`
+		Ref    string `json:"ref"`
+	}{
+		Branch: name,
+		Ref:    commitID,
+	}
+
+	// Make the API call to create the new branch.
+	u := fmt.Sprintf(gitlabBranchesURL, g.address, g.projectID(t))
+	if err := g.do(ctx, "POST", u, options, nil); err != nil {
+		return fmt.Errorf("error creating branch %q: %v", name, err)
+	}
+
+	return nil
+}
+
+// Read implements ConfigStore. The ID of the last commit that changed the file
+// is recorded in the task, so Write can detect changes made after reading it.
+func (g *gitlab) Read(ctx context.Context, t *Task) (string, error) {
+	u := g.fileURL(t) + "?ref=" + url.QueryEscape(t.branch)
+
+	var file struct {
+		Content      string `json:"content"`
+		Encoding     string `json:"encoding"`
+		LastCommitID string `json:"last_commit_id"`
+	}
+
+	// Make the API call to read the file.
+	if err := g.do(ctx, "GET", u, nil, &file); err != nil {
+		return "", err
+	}
+	t.configFileVersion = file.LastCommitID
+
+	if file.Encoding != "base64" {
+		return "", fmt.Errorf("unexpected file encoding: %s", file.Encoding)
+	}
+
+	content, err := base64.StdEncoding.DecodeString(file.Content)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// Write implements ConfigStore. The last commit ID of the file that was read is
+// sent along, so GitLab rejects the commit when the file was changed in the
+// meantime, instead of overwriting those changes.
+func (g *gitlab) Write(ctx context.Context, t *Task, content, message string) error {
+	if t.configFileVersion == "" {
+		return fmt.Errorf("unknown last commit of %q, the file has to be read first", t.configFile)
+	}
+
+	options := struct {
+		Branch        string `json:"branch"`
+		Content       string `json:"content"`
+		CommitMessage string `json:"commit_message"`
+		LastCommitID  string `json:"last_commit_id"`
+	}{
+		Branch:        t.branch,
+		Content:       content,
+		CommitMessage: message,
+		LastCommitID:  t.configFileVersion,
+	}
+
+	// Make the API call to write and commit the updated file.
+	err := g.do(ctx, "PUT", g.fileURL(t), options, nil)
+
+	var gerr *gitlabError
+	if errors.As(err, &gerr) && gerr.conflict() {
+		return fmt.Errorf("%w: %v", errCommitConflict, err)
+	}
+	return err
+}
+
+// projectID returns the URL encoded path of the project of the task.
+func (g *gitlab) projectID(t *Task) string {
+	return escapePath(t.project + "/" + t.repo)
+}
+
+// fileURL returns the Repository Files API URL of the config file of the task.
+func (g *gitlab) fileURL(t *Task) string {
+	return fmt.Sprintf(gitlabFileURL, g.address, g.projectID(t), escapePath(t.configFile))
+}
+
+// escapePath escapes a path so it can be used as a single path segment, as
+// required by the GitLab API for project and file paths.
+func escapePath(path string) string {
+	return strings.Replace(url.PathEscape(path), "/", "%2F", -1)
+}
+
+// do makes a GitLab API call. If body is not nil, it's JSON encoded and used
+// as the request body. If v is not nil, the response is decoded into v.
+func (g *gitlab) do(ctx context.Context, method, u string, body, v interface{}) error {
+	buf := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+	}
+
+	// Create the request.
+	req, err := http.NewRequest(method, u, buf)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("PRIVATE-TOKEN", g.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Make the API call.
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &gitlabError{statusCode: resp.StatusCode, status: resp.Status}
+
+		// Try to parse the error in order to get a descriptive error.
+		json.NewDecoder(resp.Body).Decode(e)
+
+		return e
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeGitLab serves a single file using the Repository Files API. The file has
+// to be written using the ID of its last commit, like GitLab does when the
+// last_commit_id is given.
+type fakeGitLab struct {
+	lastCommitID string
+	content      string
+	writes       int
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.EscapedPath() != "/api/v4/projects/group%2Frepo/repository/files/envs%2Fmain.tf" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		json.NewEncoder(w).Encode(map[string]string{
+			"content":        base64.StdEncoding.EncodeToString([]byte(f.content)),
+			"encoding":       "base64",
+			"last_commit_id": f.lastCommitID,
+		})
+	case "PUT":
+		var options struct {
+			Content      string `json:"content"`
+			LastCommitID string `json:"last_commit_id"`
+		}
+		json.NewDecoder(r.Body).Decode(&options)
+
+		if options.LastCommitID != f.lastCommitID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"message": "You are attempting to update a file that has changed since you started editing it.",
+			})
+			return
+		}
+
+		f.content = options.Content
+		f.lastCommitID += "+"
+		f.writes++
+	}
+}
+
+func TestGitLabWrite(t *testing.T) {
+	fake := &fakeGitLab{lastCommitID: "a1", content: "terraform {}\n"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	g := &gitlab{address: server.URL, client: server.Client()}
+	task := &Task{project: "group", repo: "repo", branch: "master", configFile: "envs/main.tf"}
+	ctx := context.Background()
+
+	content, err := g.Read(ctx, task)
+	if err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	if content != "terraform {}\n" || task.configFileVersion != "a1" {
+		t.Fatalf("unexpected content %q or last commit %q", content, task.configFileVersion)
+	}
+
+	if err := g.Write(ctx, task, "terraform { backend \"remote\" {} }\n", "Update"); err != nil {
+		t.Fatalf("unexpected error writing the file: %v", err)
+	}
+	if fake.writes != 1 || fake.content != "terraform { backend \"remote\" {} }\n" {
+		t.Fatalf("expected the file to be written once, got %d writes and content %q", fake.writes, fake.content)
+	}
+
+	// Someone else changes the file after it was read.
+	stale := &Task{project: "group", repo: "repo", branch: "master", configFile: "envs/main.tf"}
+	if _, err := g.Read(ctx, stale); err != nil {
+		t.Fatalf("unexpected error reading the file: %v", err)
+	}
+	fake.content, fake.lastCommitID = "terraform {}\n# changed\n", "b2"
+
+	err = g.Write(ctx, stale, "terraform { backend \"remote\" {} }\n", "Update")
+	if !errors.Is(err, errCommitConflict) {
+		t.Fatalf("expected a commit conflict, got: %v", err)
+	}
+	if fake.writes != 1 || fake.content != "terraform {}\n# changed\n" {
+		t.Fatalf("expected the changed file to be left alone, got %d writes and content %q", fake.writes, fake.content)
+	}
+}
+
+func TestGitLabWriteUnread(t *testing.T) {
+	g := &gitlab{address: "http://127.0.0.1:0", client: http.DefaultClient}
+	task := &Task{project: "group", repo: "repo", branch: "master", configFile: "main.tf"}
+
+	if err := g.Write(context.Background(), task, "", "Update"); err == nil {
+		t.Fatalf("expected an error writing a file that wasn't read")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// gzipMagic are the first bytes of gzip compressed data. States are detected
+// as compressed by these bytes, as plain states always start with a "{".
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// gunzip returns the decompressed state, if the state is gzip compressed.
+// Otherwise the state is returned as is.
+func gunzip(state []byte) ([]byte, error) {
+	if !bytes.HasPrefix(state, gzipMagic) {
+		return state, nil
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(state))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// gunzipFile returns a temporary file in dir containing the decompressed
+// state, if the state in f is gzip compressed. In that case f is removed.
+// Otherwise f is returned as is.
+func gunzipFile(f *os.File, dir string) (*os.File, error) {
+	magic := make([]byte, len(gzipMagic))
+	if _, err := f.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		return f, nil
+	}
+
+	r, err := gzip.NewReader(io.NewSectionReader(f, 0, 1<<63-1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	out, err := ioutil.TempFile(dir, "tf-tfe-*.tfstate")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, err
+	}
+
+	f.Close()
+	os.Remove(f.Name())
+
+	return out, nil
+}
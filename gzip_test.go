@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFixture returns the content of the file in testdata.
+func readFixture(t *testing.T, name string) []byte {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestGunzip(t *testing.T) {
+	plain := readFixture(t, "state.tfstate")
+	compressed := readFixture(t, "state.tfstate.gz")
+
+	for _, state := range [][]byte{plain, compressed} {
+		got, err := gunzip(state)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("expected the plain state, got %q", got)
+		}
+	}
+
+	// Data starting with the magic bytes that isn't gzip compressed fails.
+	if _, err := gunzip(append(append([]byte{}, gzipMagic...), "not gzip"...)); err == nil {
+		t.Errorf("expected an error for corrupt gzip data")
+	}
+}
+
+func TestGunzipFile(t *testing.T) {
+	plain := readFixture(t, "state.tfstate")
+	dir := t.TempDir()
+
+	for _, name := range []string{"state.tfstate", "state.tfstate.gz"} {
+		f, err := ioutil.TempFile(dir, "tf-tfe-*.tfstate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(readFixture(t, name)); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := gunzipFile(f, dir)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		defer out.Close()
+
+		// Only a compressed state is replaced by a new file.
+		compressed := name == "state.tfstate.gz"
+		if replaced := out != f; replaced != compressed {
+			t.Errorf("%s: expected the file to be replaced: %t", name, compressed)
+		}
+		if _, err := os.Stat(f.Name()); os.IsNotExist(err) != compressed {
+			t.Errorf("%s: expected the compressed file to be removed: %t", name, compressed)
+		}
+
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%s: expected the plain state, got %q", name, got)
+		}
+	}
+}
+
+func TestDownloadStateGzip(t *testing.T) {
+	plain := readFixture(t, "state.tfstate")
+	m := newFakeS3(map[string][]byte{"app.tfstate": readFixture(t, "state.tfstate.gz")})
+
+	// Download the state both in memory and to a temporary file.
+	for _, tempDir := range []string{"", t.TempDir()} {
+		m.tempDir = tempDir
+		task := &Task{source: s3Source, bucket: "states", key: "app.tfstate", meta: &Meta{}}
+
+		if err := m.downloadState(context.Background(), task); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer task.removeStateFile()
+
+		state := task.stateReader()
+		if _, err := state.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("expected the decompressed state, got %q", got)
+		}
+		if task.meta.Serial != 12 || task.meta.Lineage != "5b2c9a0e-3f41-4c6e-9d1a-7e8f0b6c2d43" {
+			t.Errorf("unexpected metadata: %+v", task.meta)
+		}
+	}
+}
@@ -0,0 +1,681 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errNoTerraformBlock is returned when the configuration has no terraform block.
+var errNoTerraformBlock = errors.New("no terraform configuration block found")
+
+// hclBlock contains the position of a block in an HCL configuration.
+type hclBlock struct {
+	Type   string
+	Labels []string
+	Blocks []*hclBlock
+
+	// Offsets of the block type, the opening brace, the closing brace and
+	// the first byte after the block.
+	Start int
+	Open  int
+	Close int
+	End   int
+}
+
+type tokenType int
+
+const (
+	tokenIdent tokenType = iota
+	tokenString
+	tokenHeredoc
+	tokenOpenBrace
+	tokenCloseBrace
+	tokenOpenBracket
+	tokenCloseBracket
+	tokenEqual
+	tokenNewline
+	tokenComment
+	tokenOther
+)
+
+type token struct {
+	typ   tokenType
+	start int
+	end   int
+	value string
+}
+
+// parseBlocks parses the HCL configuration and returns all (nested) blocks.
+// It only parses the structure of the configuration, but it does so while
+// taking strings, comments and heredocs into account, so braces inside them
+// are not mistaken for the start or end of a block.
+func parseBlocks(src string) ([]*hclBlock, error) {
+	tokens, err := scanTokens(src)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks, _, err := parseBody(tokens, 0, false)
+	return blocks, err
+}
+
+// parseBody parses the blocks in a body. If nested is true the body is the
+// body of a block, and the index of its closing brace is returned.
+func parseBody(tokens []token, i int, nested bool) ([]*hclBlock, int, error) {
+	var blocks []*hclBlock
+
+	for i < len(tokens) {
+		switch t := tokens[i]; t.typ {
+		case tokenNewline:
+			i++
+			continue
+		case tokenCloseBrace:
+			if nested {
+				return blocks, i, nil
+			}
+			return nil, 0, fmt.Errorf("unexpected '}' at offset %d", t.start)
+		case tokenIdent:
+			// A block type is followed by zero or more labels and
+			// the opening brace of the body.
+			j := i + 1
+			var labels []string
+			for j < len(tokens) && (tokens[j].typ == tokenString || tokens[j].typ == tokenIdent) {
+				labels = append(labels, tokens[j].value)
+				j++
+			}
+			if j < len(tokens) && tokens[j].typ == tokenOpenBrace {
+				body, k, err := parseBody(tokens, j+1, true)
+				if err != nil {
+					return nil, 0, err
+				}
+				blocks = append(blocks, &hclBlock{
+					Type:   t.value,
+					Labels: labels,
+					Blocks: body,
+					Start:  t.start,
+					Open:   tokens[j].start,
+					Close:  tokens[k].start,
+					End:    tokens[k].end,
+				})
+				i = k + 1
+				continue
+			}
+		}
+
+		// Anything else is part of an attribute, so skip to the end of it.
+		i = skipAttribute(tokens, i)
+	}
+
+	if nested {
+		return nil, 0, errors.New("unexpected end of configuration, missing '}'")
+	}
+
+	return blocks, i, nil
+}
+
+// skipAttribute returns the index of the first token after the attribute
+// starting at index i.
+func skipAttribute(tokens []token, i int) int {
+	depth := 0
+
+	for ; i < len(tokens); i++ {
+		switch tokens[i].typ {
+		case tokenOpenBrace, tokenOpenBracket:
+			depth++
+		case tokenCloseBrace, tokenCloseBracket:
+			if depth == 0 {
+				// This closes the enclosing body.
+				return i
+			}
+			depth--
+		case tokenNewline:
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// scanTokens splits the source into the tokens needed to parse the structure
+// of the configuration. Whitespace and comments are skipped.
+func scanTokens(src string) ([]token, error) {
+	tokens, err := scanTokensWithComments(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var filtered []token
+	for _, t := range tokens {
+		if t.typ != tokenComment {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered, nil
+}
+
+// scanTokensWithComments is like scanTokens, but also returns the /* ... */
+// comments, as they can span multiple lines.
+func scanTokensWithComments(src string) ([]token, error) {
+	var tokens []token
+
+	for pos := 0; pos < len(src); {
+		c := src[pos]
+		start := pos
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			pos++
+			continue
+		case c == '#' || strings.HasPrefix(src[pos:], "//"):
+			// Line comments run until the end of the line.
+			if i := strings.IndexByte(src[pos:], '\n'); i != -1 {
+				pos += i
+			} else {
+				pos = len(src)
+			}
+			continue
+		case strings.HasPrefix(src[pos:], "/*"):
+			i := strings.Index(src[pos+2:], "*/")
+			if i == -1 {
+				return nil, fmt.Errorf("unterminated comment at offset %d", start)
+			}
+			pos += i + 4
+			tokens = append(tokens, token{typ: tokenComment, start: start, end: pos})
+			continue
+		case c == '"':
+			end, err := scanString(src, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{
+				typ:   tokenString,
+				start: start,
+				end:   end,
+				value: src[start+1 : end-1],
+			})
+			pos = end
+			continue
+		case strings.HasPrefix(src[pos:], "<<"):
+			if end, ok := scanHeredoc(src, pos); ok {
+				tokens = append(tokens, token{typ: tokenHeredoc, start: start, end: end})
+				pos = end
+				continue
+			}
+		case c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+			for pos < len(src) && isIdentifierChar(src[pos]) {
+				pos++
+			}
+			tokens = append(tokens, token{typ: tokenIdent, start: start, end: pos, value: src[start:pos]})
+			continue
+		}
+
+		typ := tokenOther
+		switch c {
+		case '\n':
+			typ = tokenNewline
+		case '{':
+			typ = tokenOpenBrace
+		case '}':
+			typ = tokenCloseBrace
+		case '[', '(':
+			typ = tokenOpenBracket
+		case ']', ')':
+			typ = tokenCloseBracket
+		case '=':
+			// Skip comparison operators and arrows.
+			if strings.HasPrefix(src[pos:], "==") || strings.HasPrefix(src[pos:], "=>") {
+				pos++
+			} else {
+				typ = tokenEqual
+			}
+		}
+		pos++
+
+		tokens = append(tokens, token{typ: typ, start: start, end: pos})
+	}
+
+	return tokens, nil
+}
+
+// scanString returns the offset after the quoted string starting at pos,
+// skipping over escape sequences and template interpolations.
+func scanString(src string, pos int) (int, error) {
+	for i := pos + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			return i + 1, nil
+		case c == '\n':
+			return 0, fmt.Errorf("unterminated string at offset %d", pos)
+		case strings.HasPrefix(src[i:], "$${") || strings.HasPrefix(src[i:], "%%{"):
+			// Escaped template sequences.
+			i += 2
+		case strings.HasPrefix(src[i:], "${") || strings.HasPrefix(src[i:], "%{"):
+			end, err := scanTemplate(src, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = end - 1
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated string at offset %d", pos)
+}
+
+// scanTemplate returns the offset after the closing brace of the template
+// interpolation or directive whose body starts at pos.
+func scanTemplate(src string, pos int) (int, error) {
+	depth := 1
+
+	for i := pos; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			end, err := scanString(src, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end - 1
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated template sequence at offset %d", pos)
+}
+
+// scanHeredoc returns the offset after the end marker of the heredoc starting
+// at pos. If pos is not the start of a valid heredoc, ok is false.
+func scanHeredoc(src string, pos int) (end int, ok bool) {
+	i := pos + 2
+	if i < len(src) && src[i] == '-' {
+		i++
+	}
+
+	// The marker is an identifier directly followed by a newline.
+	start := i
+	for i < len(src) && isIdentifierChar(src[i]) {
+		i++
+	}
+	marker := src[start:i]
+	if marker == "" {
+		return 0, false
+	}
+	if strings.HasPrefix(src[i:], "\r\n") {
+		i++
+	}
+	if i >= len(src) || src[i] != '\n' {
+		return 0, false
+	}
+
+	// Find the line containing only the marker.
+	for i < len(src) {
+		lineStart := i + 1
+		lineEnd := strings.IndexByte(src[lineStart:], '\n')
+		if lineEnd == -1 {
+			lineEnd = len(src)
+		} else {
+			lineEnd += lineStart
+		}
+		if strings.TrimSpace(src[lineStart:lineEnd]) == marker {
+			return lineStart + strings.Index(src[lineStart:lineEnd], marker) + len(marker), true
+		}
+		i = lineEnd
+	}
+
+	return 0, false
+}
+
+// isIdentifierChar reports whether c can be part of an HCL identifier.
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '-' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// replaceBackend replaces the backend (or cloud) block in the terraform block
+// of the configuration with the given backend, preserving all other settings in
+// the terraform block. If none of the terraform blocks has a backend yet, the
+// new backend is added to the end of the first terraform block. It returns the
+// updated content together with the start and end offsets of the replaced part
+// of content. The line endings and indentation of the configuration are used
+// for the new backend.
+func replaceBackend(content, backend string) (updated string, start, end int, err error) {
+	updated, start, end, err = insertBackend(content, backend)
+	if err != nil {
+		return "", start, end, err
+	}
+
+	// Only the inserted part needs to use the line endings of the
+	// configuration, as the rest of the configuration is unchanged.
+	if strings.Contains(content, "\r\n") {
+		inserted := updated[start : len(updated)-len(content)+end]
+		inserted = strings.Replace(strings.Replace(inserted, "\r\n", "\n", -1), "\n", "\r\n", -1)
+		updated = updated[:start] + inserted + content[end:]
+	}
+
+	return updated, start, end, nil
+}
+
+// insertBackend does the actual work for replaceBackend, always using line
+// feeds as line endings.
+func insertBackend(content, backend string) (updated string, start, end int, err error) {
+	blocks, err := parseBlocks(content)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	// The backend is indented using two spaces, so use the indentation of
+	// the configuration instead.
+	unit := indentUnit(content)
+	backend = reindent(backend, unit)
+
+	// A configuration can contain multiple terraform blocks, so make sure
+	// we replace the existing backend wherever it is defined.
+	var tf *hclBlock
+	for _, b := range blocks {
+		if b.Type != "terraform" {
+			continue
+		}
+		if tf == nil {
+			tf = b
+		}
+
+		for _, nested := range b.Blocks {
+			if isBackend(nested) {
+				backend = indent(backend, lineIndent(content, nested.Start))
+				return content[:nested.Start] + backend + content[nested.End:], nested.Start, nested.End, nil
+			}
+		}
+	}
+	if tf == nil {
+		return "", -1, -1, errNoTerraformBlock
+	}
+
+	// Add the backend to the end of the terraform block.
+	tfIndent := lineIndent(content, tf.Start)
+	backend = tfIndent + unit + indent(backend, tfIndent+unit)
+
+	body := strings.TrimSpace(content[tf.Open+1 : tf.Close])
+	lineStart := strings.LastIndexByte(content[:tf.Close], '\n') + 1
+	switch {
+	case lineStart > tf.Open && strings.TrimSpace(content[lineStart:tf.Close]) == "":
+		// The closing brace is on its own line.
+		if strings.TrimSpace(content[tf.Open+1:lineStart]) != "" {
+			backend = "\n" + backend
+		}
+		backend += "\n"
+		start, end = lineStart, lineStart
+	case body == "":
+		// The block is empty, like terraform {}.
+		backend = "\n" + backend + "\n" + tfIndent
+		start, end = tf.Open+1, tf.Close
+	case lineStart <= tf.Open:
+		// The whole block is on a single line, so move its body onto
+		// its own line before adding the backend.
+		backend = "\n" + tfIndent + unit + body + "\n\n" + backend + "\n" + tfIndent
+		start, end = tf.Open+1, tf.Close
+	default:
+		// The closing brace directly follows the last setting.
+		backend = "\n\n" + backend + "\n" + tfIndent
+		start, end = len(strings.TrimRight(content[:tf.Close], " \t")), tf.Close
+	}
+
+	return content[:start] + backend + content[end:], start, end, nil
+}
+
+// appendBackend adds a terraform block containing the backend to the end of
+// the configuration, using its line endings and indentation.
+func appendBackend(content, backend string) string {
+	unit := indentUnit(content)
+	block := "terraform {\n" + unit + indent(reindent(backend, unit), unit) + "\n}\n"
+
+	switch {
+	case content == "":
+	case strings.HasSuffix(content, "\n"):
+		block = "\n" + block
+	default:
+		block = "\n\n" + block
+	}
+
+	if strings.Contains(content, "\r\n") {
+		block = strings.Replace(block, "\n", "\r\n", -1)
+	}
+
+	return content + block
+}
+
+// missingTerraformBlock reports whether the configuration can be parsed but
+// does not contain a terraform block.
+func missingTerraformBlock(content string) bool {
+	blocks, err := parseBlocks(content)
+	if err != nil {
+		return false
+	}
+	for _, b := range blocks {
+		if b.Type == "terraform" {
+			return false
+		}
+	}
+	return true
+}
+
+// s3Backend returns the literal string attributes of the s3 backend in the
+// terraform blocks of the configuration, or nil if there is no s3 backend.
+func s3Backend(content string) (map[string]string, error) {
+	blocks, err := parseBlocks(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range blocks {
+		if b.Type != "terraform" {
+			continue
+		}
+		for _, nested := range b.Blocks {
+			if nested.Type == "backend" && len(nested.Labels) == 1 && nested.Labels[0] == "s3" {
+				return blockAttributes(content, nested)
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// blockAttributes returns the attributes of the block that are set to a
+// literal string. Attributes with any other value (including strings with
+// template sequences) and nested blocks are skipped.
+func blockAttributes(content string, b *hclBlock) (map[string]string, error) {
+	tokens, err := scanTokens(content[b.Open+1 : b.Close])
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := make(map[string]string)
+	for i := 0; i < len(tokens); {
+		if i+2 < len(tokens) &&
+			tokens[i].typ == tokenIdent && tokens[i+1].typ == tokenEqual && tokens[i+2].typ == tokenString &&
+			(i+3 == len(tokens) || tokens[i+3].typ == tokenNewline) {
+			value := tokens[i+2].value
+			if !strings.Contains(value, "${") && !strings.Contains(value, "%{") {
+				if unquoted, err := strconv.Unquote(`"` + value + `"`); err == nil {
+					value = unquoted
+				}
+				attributes[tokens[i].value] = value
+			}
+		}
+
+		next := skipAttribute(tokens, i)
+		if next == i {
+			next++
+		}
+		i = next
+	}
+
+	return attributes, nil
+}
+
+// isBackend reports whether the block nested in a terraform block configures
+// the backend, which is either a backend block or a cloud block.
+func isBackend(b *hclBlock) bool {
+	return b.Type == "backend" || b.Type == "cloud"
+}
+
+// hasBackend reports whether a terraform block of the configuration already
+// contains the given backend. The backends are compared token by token, so
+// differences in whitespace, line endings and comments are ignored.
+func hasBackend(content, backend string) (bool, error) {
+	blocks, err := parseBlocks(content)
+	if err != nil {
+		return false, err
+	}
+
+	for _, b := range blocks {
+		if b.Type != "terraform" {
+			continue
+		}
+		for _, nested := range b.Blocks {
+			if isBackend(nested) {
+				return sameTokens(content[nested.Start:nested.End], backend)
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// sameTokens reports whether a and b consist of the same tokens, ignoring
+// newlines.
+func sameTokens(a, b string) (bool, error) {
+	ta, err := scanTokens(a)
+	if err != nil {
+		return false, err
+	}
+	tb, err := scanTokens(b)
+	if err != nil {
+		return false, err
+	}
+
+	ta, tb = withoutNewlines(ta), withoutNewlines(tb)
+	if len(ta) != len(tb) {
+		return false, nil
+	}
+	for i := range ta {
+		if ta[i].typ != tb[i].typ || a[ta[i].start:ta[i].end] != b[tb[i].start:tb[i].end] {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// withoutNewlines returns the tokens that are not newlines.
+func withoutNewlines(tokens []token) []token {
+	var filtered []token
+	for _, t := range tokens {
+		if t.typ != tokenNewline {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// lineIndent returns the whitespace preceding pos on its line.
+func lineIndent(content string, pos int) string {
+	lineStart := strings.LastIndexByte(content[:pos], '\n') + 1
+	prefix := content[lineStart:pos]
+	if strings.TrimSpace(prefix) != "" {
+		return ""
+	}
+	return prefix
+}
+
+// indentUnit returns the string used for one level of indentation in the
+// content, which is a tab when any line is indented using tabs, or else the
+// smallest number of spaces any line is indented with. Lines inside heredocs
+// and /* ... */ comments are ignored, as they are part of a string or aligned
+// freely (like the " * " lines of a comment). It defaults to two spaces, as
+// used by terraform fmt.
+func indentUnit(content string) string {
+	var skipped []token
+	if tokens, err := scanTokensWithComments(content); err == nil {
+		for _, t := range tokens {
+			if t.typ == tokenHeredoc || t.typ == tokenComment {
+				skipped = append(skipped, t)
+			}
+		}
+	}
+
+	spaces := 0
+	offset := 0
+
+	for _, line := range strings.Split(content, "\n") {
+		lineStart := offset
+		offset += len(line) + 1
+		if inToken(skipped, lineStart) {
+			continue
+		}
+
+		trimmed := strings.TrimLeft(line, " \t")
+		if strings.TrimSpace(trimmed) == "" || len(trimmed) == len(line) {
+			continue
+		}
+		if line[0] == '\t' {
+			return "\t"
+		}
+		if n := len(line) - len(trimmed); spaces == 0 || n < spaces {
+			spaces = n
+		}
+	}
+
+	if spaces == 0 {
+		return "  "
+	}
+	return strings.Repeat(" ", spaces)
+}
+
+// inToken reports whether the line starting at pos is inside one of the
+// tokens, which includes the line with the end marker of a heredoc or the end
+// of a comment.
+func inToken(tokens []token, pos int) bool {
+	for _, t := range tokens {
+		if pos > t.start && pos < t.end {
+			return true
+		}
+	}
+	return false
+}
+
+// reindent replaces every two leading spaces of the lines of s by unit.
+func reindent(s, unit string) string {
+	if unit == "  " {
+		return s
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		depth := (len(line) - len(trimmed)) / 2
+		lines[i] = strings.Repeat(unit, depth) + line[depth*2:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// indent prefixes all but the first line of s with the given indent.
+func indent(s, indent string) string {
+	lines := strings.Split(s, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = indent + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
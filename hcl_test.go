@@ -0,0 +1,430 @@
+package main
+
+import (
+	"testing"
+)
+
+// testBackend is the backend used in the tests, indented using two spaces
+// just like the backend configuration of a task.
+const testBackend = `backend "remote" {
+  organization = "org"
+}`
+
+func TestReplaceBackendKeyword(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+		err     error
+	}{
+		{
+			name:    "block at end of file",
+			content: "terraform {\n  backend \"s3\" {}\n}",
+			want:    "terraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}",
+		},
+		{
+			name:    "empty block at end of file",
+			content: "terraform {}",
+			want:    "terraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}",
+		},
+		{
+			name:    "block without whitespace",
+			content: "terraform{\nbackend \"s3\"{}\n}\n",
+			want:    "terraform{\nbackend \"remote\" {\n  organization = \"org\"\n}\n}\n",
+		},
+		{
+			name:    "identifier starting with terraform",
+			content: "terraformish {\n  backend \"s3\" {}\n}\n",
+			err:     errNoTerraformBlock,
+		},
+		{
+			name:    "terraform as a label",
+			content: "module \"terraform\" {\n  source = \"./terraform\"\n}\n",
+			err:     errNoTerraformBlock,
+		},
+		{
+			name:    "terraform after an identifier starting with terraform",
+			content: "terraformish {}\nterraform {\n  backend \"s3\" {}\n}",
+			want:    "terraformish {}\nterraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}",
+		},
+	}
+
+	for _, c := range cases {
+		got, _, _, err := replaceBackend(c.content, testBackend)
+		if err != c.err {
+			t.Errorf("%s: expected error %v, got: %v", c.name, c.err, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: unexpected result:\n%s\nwant:\n%s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestReplaceBackendSyntax(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "braces in strings",
+			content: `terraform {
+  required_version = ">= 0.12, } {"
+  backend "s3" {
+    key = "${var.env}/{app}"
+  }
+}
+`,
+			want: `terraform {
+  required_version = ">= 0.12, } {"
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+		{
+			name: "braces in comments",
+			content: `# terraform {
+// }
+terraform {
+  /* backend "local" { */
+  backend "s3" { # }
+    bucket = "b" // {
+  }
+}
+`,
+			want: `# terraform {
+// }
+terraform {
+  /* backend "local" { */
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+		{
+			name: "braces in heredocs",
+			content: `resource "aws_iam_policy" "p" {
+  policy = <<-EOF
+    {
+      "Statement": "}"
+    EOF
+}
+
+terraform {
+  backend "s3" {}
+}
+`,
+			want: `resource "aws_iam_policy" "p" {
+  policy = <<-EOF
+    {
+      "Statement": "}"
+    EOF
+}
+
+terraform {
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+		{
+			name: "nested required_providers",
+			content: `terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+  backend "s3" {}
+}
+`,
+			want: `terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+  }
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+		{
+			name:    "CRLF line endings",
+			content: "terraform {\r\n  required_version = \">= 1.0\"\r\n  backend \"s3\" {\r\n    key = \"k\"\r\n  }\r\n}\r\n",
+			want:    "terraform {\r\n  required_version = \">= 1.0\"\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "CRLF line endings without backend",
+			content: "terraform {\r\n  required_version = \">= 1.0\"\r\n}\r\n",
+			want:    "terraform {\r\n  required_version = \">= 1.0\"\r\n\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "block comment before the terraform block",
+			content: "/*\n * Managed by the platform team.\n */\nterraform {\n  backend \"s3\" {\n    key = \"k\"\n  }\n}\n",
+			want:    "/*\n * Managed by the platform team.\n */\nterraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "single-line block",
+			content: "terraform { required_version = \">= 1.0\" }\n",
+			want:    "terraform {\n  required_version = \">= 1.0\"\n\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "single-line block with CRLF line endings",
+			content: "terraform { required_version = \">= 1.0\" }\r\n",
+			want:    "terraform {\r\n  required_version = \">= 1.0\"\r\n\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "empty block",
+			content: "terraform { }\n",
+			want:    "terraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "closing brace after the last setting",
+			content: "terraform {\n  required_version = \">= 1.0\" }\n",
+			want:    "terraform {\n  required_version = \">= 1.0\"\n\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "tab indentation",
+			content: "terraform {\n\tbackend \"s3\" {\n\t\tkey = \"k\"\n\t}\n}\n",
+			want:    "terraform {\n\tbackend \"remote\" {\n\t\torganization = \"org\"\n\t}\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		got, _, _, err := replaceBackend(c.content, testBackend)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: unexpected result:\n%q\nwant:\n%q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestParseBlocksErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"unterminated string", "terraform {\n  required_version = \">= 1.0\n}\n"},
+		{"unterminated comment", "terraform {\n  /* backend \"s3\" {}\n}\n"},
+		{"missing closing brace", "terraform {\n  backend \"s3\" {}\n"},
+		{"unexpected closing brace", "terraform {}\n}\n"},
+	}
+
+	for _, c := range cases {
+		if _, err := parseBlocks(c.content); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestIndentUnit(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		unit    string
+	}{
+		{"default", "terraform {}\n", "  "},
+		{"two spaces", "terraform {\n  backend \"s3\" {\n    key = \"k\"\n  }\n}\n", "  "},
+		{"four spaces", "terraform {\n    backend \"s3\" {\n        key = \"k\"\n    }\n}\n", "    "},
+		{"tabs", "terraform {\n\tbackend \"s3\" {}\n}\n", "\t"},
+		{
+			name:    "heredoc indented with one space",
+			content: "locals {\n    script = <<EOF\n #!/bin/sh\n echo hi\nEOF\n}\n",
+			unit:    "    ",
+		},
+		{
+			name:    "heredoc indented with tabs",
+			content: "locals {\n  script = <<-EOF\n\t\techo hi\n\tEOF\n}\n",
+			unit:    "  ",
+		},
+		{
+			name:    "block comment",
+			content: "/*\n * Managed by the platform team.\n */\nterraform {\n  backend \"s3\" {}\n}\n",
+			unit:    "  ",
+		},
+		{
+			name:    "indented block comment",
+			content: "terraform {\n    /*\n     * The backend.\n     */\n    backend \"s3\" {}\n}\n",
+			unit:    "    ",
+		},
+	}
+
+	for _, c := range cases {
+		if got := indentUnit(c.content); got != c.unit {
+			t.Errorf("%s: indentUnit() = %q, want %q", c.name, got, c.unit)
+		}
+	}
+}
+
+func TestReplaceBackendPreservesSettings(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name: "replace backend",
+			content: `terraform {
+  required_version = ">= 1.0"
+
+  backend "s3" {
+    bucket = "b"
+    key    = "k"
+  }
+
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+}
+`,
+			want: `terraform {
+  required_version = ">= 1.0"
+
+  backend "remote" {
+    organization = "org"
+  }
+
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+}
+`,
+		},
+		{
+			name: "add backend",
+			content: `terraform {
+  required_version = ">= 1.0"
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+}
+`,
+			want: `terraform {
+  required_version = ">= 1.0"
+  required_providers {
+    aws = {
+      source = "hashicorp/aws"
+    }
+  }
+
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+		{
+			name: "replace cloud block",
+			content: `terraform {
+  cloud {
+    organization = "old"
+  }
+  required_version = "~> 1.5"
+}
+`,
+			want: `terraform {
+  backend "remote" {
+    organization = "org"
+  }
+  required_version = "~> 1.5"
+}
+`,
+		},
+		{
+			name: "backend in second terraform block",
+			content: `terraform {
+  required_version = ">= 1.0"
+}
+
+terraform {
+  backend "s3" {}
+}
+`,
+			want: `terraform {
+  required_version = ">= 1.0"
+}
+
+terraform {
+  backend "remote" {
+    organization = "org"
+  }
+}
+`,
+		},
+	}
+
+	for _, c := range cases {
+		got, _, _, err := replaceBackend(c.content, testBackend)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: unexpected result:\n%s\nwant:\n%s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestAppendBackend(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "empty file",
+			content: "",
+			want:    "terraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "trailing newline",
+			content: "resource \"null_resource\" \"r\" {}\n",
+			want:    "resource \"null_resource\" \"r\" {}\n\nterraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "no trailing newline",
+			content: "resource \"null_resource\" \"r\" {}",
+			want:    "resource \"null_resource\" \"r\" {}\n\nterraform {\n  backend \"remote\" {\n    organization = \"org\"\n  }\n}\n",
+		},
+		{
+			name:    "CRLF line endings",
+			content: "resource \"null_resource\" \"r\" {}\r\n",
+			want:    "resource \"null_resource\" \"r\" {}\r\n\r\nterraform {\r\n  backend \"remote\" {\r\n    organization = \"org\"\r\n  }\r\n}\r\n",
+		},
+		{
+			name:    "tab indentation",
+			content: "locals {\n\ta = 1\n}\n",
+			want:    "locals {\n\ta = 1\n}\n\nterraform {\n\tbackend \"remote\" {\n\t\torganization = \"org\"\n\t}\n}\n",
+		},
+	}
+
+	for _, c := range cases {
+		got := appendBackend(c.content, testBackend)
+		if got != c.want {
+			t.Errorf("%s: unexpected result:\n%q\nwant:\n%q", c.name, got, c.want)
+		}
+
+		// The result contains the backend, so the next run leaves it alone.
+		if ok, err := hasBackend(got, testBackend); err != nil || !ok {
+			t.Errorf("%s: expected the result to contain the backend (err: %v)", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// stateVersion is a previous version of a state.
+type stateVersion struct {
+	state []byte
+	meta  *Meta
+}
+
+// HistoryDownloader is implemented by state downloaders that can download
+// previous versions of a state.
+type HistoryDownloader interface {
+	// DownloadHistory returns up to n previous versions of the state of
+	// the task, newest first.
+	DownloadHistory(ctx context.Context, t *Task, n int) ([][]byte, error)
+}
+
+// DownloadHistory implements HistoryDownloader. It requires versioning to be
+// enabled on the bucket.
+func (d *s3Downloader) DownloadHistory(ctx context.Context, t *Task, n int) ([][]byte, error) {
+	client := d.clients.client(t.roleARN)
+
+	// Collect the IDs of the previous versions, which are listed newest first.
+	var ids []string
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(t.bucket),
+		Prefix: aws.String(t.key),
+	}
+	err := client.ListObjectVersionsPagesWithContext(ctx, input, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, v := range page.Versions {
+			if aws.StringValue(v.Key) != t.key || aws.BoolValue(v.IsLatest) {
+				continue
+			}
+			if ids = append(ids, aws.StringValue(v.VersionId)); len(ids) == n {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, s3Error(t, err)
+	}
+
+	var states [][]byte
+	for _, id := range ids {
+		output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket:    aws.String(t.bucket),
+			Key:       aws.String(t.key),
+			VersionId: aws.String(id),
+		})
+		if err != nil {
+			return nil, s3Error(t, err)
+		}
+
+		var body io.Reader = output.Body
+		if d.limiter != nil {
+			body = &rateLimitedReader{ctx: ctx, limiter: d.limiter, r: body}
+		}
+
+		state, err := ioutil.ReadAll(body)
+		output.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// downloadHistory downloads the previous versions of the state of the task,
+// up to the configured history depth. Only versions of the same lineage with
+// a lower serial than the current state are kept, ordered by serial so they
+// can be uploaded in order.
+func (m *Migrator) downloadHistory(ctx context.Context, t *Task) error {
+	if m.historyDepth <= 1 {
+		return nil
+	}
+
+	d, ok := m.downloaders[t.source].(HistoryDownloader)
+	if !ok {
+		t.logger().Warn("State history is not supported for this source, only migrating the current state", "source", t.source)
+		return nil
+	}
+
+	states, err := d.DownloadHistory(ctx, t, m.historyDepth-1)
+	if err != nil {
+		return err
+	}
+
+	t.history = nil
+	for _, state := range states {
+		state, err := gunzip(state)
+		if err != nil {
+			t.logger().Warn("Skipping previous state version that cannot be decompressed", "error", err)
+			continue
+		}
+
+		meta := &Meta{}
+		if err := readMeta(bytes.NewReader(state), meta); err != nil {
+			t.logger().Warn("Skipping previous state version that cannot be parsed", "error", err)
+			continue
+		}
+		if meta.Lineage != t.meta.Lineage || meta.Serial >= t.meta.Serial {
+			t.logger().Warn("Skipping previous state version", "lineage", meta.Lineage, "serial", meta.Serial)
+			continue
+		}
+		t.history = append(t.history, &stateVersion{state: state, meta: meta})
+	}
+
+	// The versions are newest first, so using a stable sort the newest
+	// version comes first when multiple versions share the same serial.
+	sort.SliceStable(t.history, func(i, j int) bool {
+		return t.history[i].meta.Serial < t.history[j].meta.Serial
+	})
+
+	// Only keep the newest version of each serial.
+	var history []*stateVersion
+	for i, v := range t.history {
+		if i > 0 && t.history[i-1].meta.Serial == v.meta.Serial {
+			continue
+		}
+		history = append(history, v)
+	}
+	t.history = history
+
+	return nil
+}
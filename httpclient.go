@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The default timeout of requests to the VCS providers.
+const defaultHTTPTimeout = 60 * time.Second
+
+// newHTTPClient returns the HTTP client used to talk to the VCS providers.
+func newHTTPClient(timeout time.Duration, caFile string, insecure bool, proxy *url.URL, maxRetries int) (*http.Client, error) {
+	transport, err := newTransport(caFile, insecure, proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &retryTransport{
+			maxRetries: maxRetries,
+			next:       transport,
+		},
+	}, nil
+}
+
+// newTransport returns a copy of the default transport. The CA certificates
+// in caFile (if set) are trusted in addition to the system roots, which is
+// needed for self-hosted instances using an internal CA. All requests are sent
+// through the proxy if set, otherwise the proxy environment variables are used.
+func newTransport(caFile string, insecure bool, proxy *url.URL) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if caFile != "" || insecure {
+		config := &tls.Config{InsecureSkipVerify: insecure}
+
+		if caFile != "" {
+			pem, err := ioutil.ReadFile(caFile)
+			if err != nil {
+				return nil, err
+			}
+
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", caFile)
+			}
+			config.RootCAs = pool
+		}
+
+		transport.TLSClientConfig = config
+	}
+
+	return transport, nil
+}
+
+// parseProxy parses the URL of the proxy, which is nil when not set.
+func parseProxy(s string) (*url.URL, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", s, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: the scheme must be http, https or socks5", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", s)
+	}
+
+	return u, nil
+}
+
+// retryTransport is an http.RoundTripper that retries requests that failed
+// with a 5xx server error, backing off between attempts. Only requests that
+// don't change anything (GET and HEAD) are retried, as a server error doesn't
+// mean that a commit, branch or pull request wasn't created, in which case
+// retrying it would create it twice or fail with a conflict.
+type retryTransport struct {
+	maxRetries int
+	next       http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode < 500 || attempt > t.maxRetries {
+			return resp, err
+		}
+
+		// The body has to be read again, which is only possible when
+		// the request knows how to get a new copy of it.
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		select {
+		case <-time.After(backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
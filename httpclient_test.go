@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRetryTransport(t *testing.T) {
+	cases := []struct {
+		method   string
+		status   int
+		attempts int32
+	}{
+		{"GET", http.StatusOK, 2},
+		{"HEAD", http.StatusOK, 2},
+		{"POST", http.StatusBadGateway, 1},
+		{"PUT", http.StatusBadGateway, 1},
+	}
+
+	for _, c := range cases {
+		// The first attempt fails with a server error.
+		var attempts int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&attempts, 1) == 1 {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}))
+
+		client := &http.Client{Transport: &retryTransport{maxRetries: 1, next: http.DefaultTransport}}
+
+		req, err := http.NewRequest(c.method, server.URL, strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.method, err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode != c.status {
+				t.Errorf("%s: expected status %d, got %d", c.method, c.status, resp.StatusCode)
+			}
+		}
+		if attempts != c.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", c.method, c.attempts, attempts)
+		}
+
+		server.Close()
+	}
+}
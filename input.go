@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Supported input formats.
+const (
+	csvFormat  = "csv"
+	jsonFormat = "json"
+)
+
+// stdinInput is the input path used to read the input from stdin.
+const stdinInput = "-"
+
+// Names of the columns that can be used in the header of the input file.
+const (
+	bucketColumn     = "bucket"
+	keyColumn        = "key"
+	projectColumn    = "project"
+	repoColumn       = "repo"
+	branchColumn     = "branch"
+	configFileColumn = "configFile"
+	workspaceColumn  = "workspace"
+
+	// Optional columns.
+	tfeProjectColumn    = "tfe_project"
+	oauthTokenIDColumn  = "oauth_token_id"
+	executionModeColumn = "execution_mode"
+	agentPoolIDColumn   = "agent_pool_id"
+	tagsColumn          = "tags"
+	vcsColumn           = "vcs"
+	roleARNColumn       = "role_arn"
+	kmsKeyIDColumn      = "kms_key_id"
+	varsColumn          = "vars"
+	organizationColumn  = "organization"
+	workspaceIDColumn   = "workspace_id"
+	teamAccessColumn    = "team_access"
+
+	// Optional Terraform version overriding the version of the state.
+	terraformVersionColumn = "terraform_version"
+
+	// Optional boolean workspace settings.
+	autoApplyColumn           = "auto_apply"
+	queueAllRunsColumn        = "queue_all_runs"
+	fileTriggersEnabledColumn = "file_triggers_enabled"
+	speculativeEnabledColumn  = "speculative_enabled"
+
+	// Optional settings for workspaces in monorepos.
+	workingDirectoryColumn = "working_directory"
+	triggerPrefixesColumn  = "trigger_prefixes"
+
+	// Optional tags used to select the workspace in a cloud block.
+	cloudTagsColumn = "cloud_tags"
+)
+
+// requiredColumns contains the columns that are expected in each record. When
+// the input file does not have a header, the fields are expected to be in this
+// exact order.
+var requiredColumns = []string{
+	bucketColumn,
+	keyColumn,
+	projectColumn,
+	repoColumn,
+	branchColumn,
+	configFileColumn,
+	workspaceColumn,
+}
+
+// optionalColumns contains the columns that can only be used in combination
+// with a header.
+var optionalColumns = []string{
+	tfeProjectColumn,
+	oauthTokenIDColumn,
+	executionModeColumn,
+	agentPoolIDColumn,
+	tagsColumn,
+	vcsColumn,
+	roleARNColumn,
+	kmsKeyIDColumn,
+	varsColumn,
+	organizationColumn,
+	workspaceIDColumn,
+	teamAccessColumn,
+	terraformVersionColumn,
+	autoApplyColumn,
+	queueAllRunsColumn,
+	fileTriggersEnabledColumn,
+	speculativeEnabledColumn,
+	workingDirectoryColumn,
+	triggerPrefixesColumn,
+	cloudTagsColumn,
+}
+
+// toggleColumns maps the columns of the boolean workspace settings to the
+// names of their workspace attributes.
+var toggleColumns = map[string]string{
+	autoApplyColumn:           "auto-apply",
+	queueAllRunsColumn:        "queue-all-runs",
+	fileTriggersEnabledColumn: "file-triggers-enabled",
+	speculativeEnabledColumn:  "speculative-enabled",
+}
+
+// readTasks reads all records from the input and returns a task for each
+// record. If the first record is a header, the columns are looked up by name
+// so their order doesn't matter. When skipBadRows is set, records with an
+// unexpected number of fields are skipped and returned as errors instead of
+// failing the whole input.
+func readTasks(input io.Reader, skipBadRows bool) ([]*Task, []error, error) {
+	r := csv.NewReader(input)
+
+	// We check the number of fields ourselves, so bad rows can be skipped.
+	r.FieldsPerRecord = -1
+
+	var columns map[string]int
+	var tasks []*Task
+	var badRows []error
+
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if columns == nil {
+			if isHeader(record) {
+				if columns, err = parseHeader(record); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			columns = defaultColumns()
+		}
+
+		if len(record) != len(columns) {
+			err := fmt.Errorf(
+				"Unexpected number of fields (%d) in record %d: %v", len(record), line, record,
+			)
+			if !skipBadRows {
+				return nil, nil, err
+			}
+			badRows = append(badRows, err)
+			continue
+		}
+
+		// field returns the value of the named column in this record.
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return record[i]
+			}
+			return ""
+		}
+
+		// The bucket can be prefixed with the source of the state.
+		source, bucket := parseSource(field(bucketColumn))
+
+		task := &Task{
+			record:     line,
+			source:     source,
+			vcs:        field(vcsColumn),
+			bucket:     bucket,
+			key:        field(keyColumn),
+			project:    field(projectColumn),
+			repo:       field(repoColumn),
+			branch:     field(branchColumn),
+			configFile: field(configFileColumn),
+			workspace:  field(workspaceColumn),
+
+			organization: field(organizationColumn),
+			workspaceID:  field(workspaceIDColumn),
+
+			tfeProject:   field(tfeProjectColumn),
+			oauthTokenID: field(oauthTokenIDColumn),
+
+			executionMode: field(executionModeColumn),
+			agentPoolID:   field(agentPoolIDColumn),
+			tags:          parseList(field(tagsColumn)),
+
+			roleARN:  field(roleARNColumn),
+			kmsKeyID: field(kmsKeyIDColumn),
+			varsFile: field(varsColumn),
+
+			terraformVersion: field(terraformVersionColumn),
+
+			workingDirectory: field(workingDirectoryColumn),
+			triggerPrefixes:  parseList(field(triggerPrefixesColumn)),
+
+			cloudTags: parseList(field(cloudTagsColumn)),
+
+			toggles: make(map[string]bool),
+
+			meta: &Meta{},
+		}
+
+		task.teamAccess, err = parseTeamAccess(field(teamAccessColumn))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s value in record %d: %v", teamAccessColumn, line, err)
+		}
+
+		// Only set the boolean settings that have a value, so the
+		// others use the defaults.
+		for column, attribute := range toggleColumns {
+			if v := field(column); v != "" {
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, nil, fmt.Errorf("Invalid %s value %q in record %d", column, v, line)
+				}
+				task.toggles[attribute] = b
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, badRows, nil
+}
+
+// jsonTask represents a single task in a JSON input file.
+type jsonTask struct {
+	Bucket     string `json:"bucket"`
+	Key        string `json:"key"`
+	Project    string `json:"project"`
+	Repo       string `json:"repo"`
+	Branch     string `json:"branch"`
+	ConfigFile string `json:"config_file"`
+	Workspace  string `json:"workspace"`
+
+	// Optional settings.
+	Organization     string   `json:"organization"`
+	WorkspaceID      string   `json:"workspace_id"`
+	VCS              string   `json:"vcs"`
+	TFEProject       string   `json:"tfe_project"`
+	OAuthTokenID     string   `json:"oauth_token_id"`
+	ExecutionMode    string   `json:"execution_mode"`
+	AgentPoolID      string   `json:"agent_pool_id"`
+	Tags             []string `json:"tags"`
+	TerraformVersion string   `json:"terraform_version"`
+	RoleARN          string   `json:"role_arn"`
+	KMSKeyID         string   `json:"kms_key_id"`
+	Vars             string   `json:"vars"`
+
+	// Optional access of teams to the workspace by team name.
+	TeamAccess map[string]string `json:"team_access"`
+
+	// Optional boolean settings, nil means the default is used.
+	AutoApply           *bool `json:"auto_apply"`
+	QueueAllRuns        *bool `json:"queue_all_runs"`
+	FileTriggersEnabled *bool `json:"file_triggers_enabled"`
+	SpeculativeEnabled  *bool `json:"speculative_enabled"`
+
+	// Optional settings for workspaces in monorepos.
+	WorkingDirectory string   `json:"working_directory"`
+	TriggerPrefixes  []string `json:"trigger_prefixes"`
+
+	// Optional tags used to select the workspace in a cloud block.
+	CloudTags []string `json:"cloud_tags"`
+}
+
+// readJSONTasks reads a JSON array of tasks from the input.
+func readJSONTasks(input io.Reader) ([]*Task, error) {
+	d := json.NewDecoder(input)
+	d.DisallowUnknownFields()
+
+	var entries []jsonTask
+	if err := d.Decode(&entries); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]*Task, 0, len(entries))
+	for i, e := range entries {
+		// The bucket can be prefixed with the source of the state.
+		source, bucket := parseSource(e.Bucket)
+
+		task := &Task{
+			record:     i + 1,
+			source:     source,
+			vcs:        e.VCS,
+			bucket:     bucket,
+			key:        e.Key,
+			project:    e.Project,
+			repo:       e.Repo,
+			branch:     e.Branch,
+			configFile: e.ConfigFile,
+			workspace:  e.Workspace,
+
+			organization: e.Organization,
+			workspaceID:  e.WorkspaceID,
+
+			tfeProject:   e.TFEProject,
+			oauthTokenID: e.OAuthTokenID,
+
+			executionMode:    e.ExecutionMode,
+			agentPoolID:      e.AgentPoolID,
+			tags:             parseList(strings.Join(e.Tags, ",")),
+			terraformVersion: e.TerraformVersion,
+
+			teamAccess: e.TeamAccess,
+
+			roleARN:  e.RoleARN,
+			kmsKeyID: e.KMSKeyID,
+			varsFile: e.Vars,
+
+			workingDirectory: e.WorkingDirectory,
+			triggerPrefixes:  e.TriggerPrefixes,
+
+			cloudTags: e.CloudTags,
+
+			toggles: make(map[string]bool),
+
+			meta: &Meta{},
+		}
+
+		if err := validateTeamAccess(task.teamAccess); err != nil {
+			return nil, fmt.Errorf("Invalid %s value in task %d: %v", teamAccessColumn, i+1, err)
+		}
+
+		for column, value := range map[string]*bool{
+			autoApplyColumn:           e.AutoApply,
+			queueAllRunsColumn:        e.QueueAllRuns,
+			fileTriggersEnabledColumn: e.FileTriggersEnabled,
+			speculativeEnabledColumn:  e.SpeculativeEnabled,
+		} {
+			if value != nil {
+				task.toggles[toggleColumns[column]] = *value
+			}
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// validateTasks validates all tasks before any of them is started. Instead of
+// stopping at the first invalid task, all errors are collected and returned
+// together.
+func validateTasks(tasks []*Task) error {
+	var errs []string
+	workspaces := make(map[string]int)
+
+	for _, t := range tasks {
+		for _, err := range validateTask(t) {
+			errs = append(errs, fmt.Sprintf("record %d: %v", t.record, err))
+		}
+
+		// Tasks with a key prefix use the workspace as a template.
+		if t.workspace == "" || isPrefix(t.key) {
+			continue
+		}
+		if record, ok := workspaces[t.qualifiedName()]; ok {
+			errs = append(errs, fmt.Sprintf(
+				"record %d: Duplicate workspace %q (also used in record %d)", t.record, t.workspace, record,
+			))
+			continue
+		}
+		workspaces[t.qualifiedName()] = t.record
+	}
+
+	// Every existing workspace can only be targeted once as well.
+	workspaceIDs := make(map[string]int)
+	for _, t := range tasks {
+		if t.workspaceID == "" {
+			continue
+		}
+		if record, ok := workspaceIDs[t.workspaceID]; ok {
+			errs = append(errs, fmt.Sprintf(
+				"record %d: Duplicate workspace ID %q (also used in record %d)", t.record, t.workspaceID, record,
+			))
+			continue
+		}
+		workspaceIDs[t.workspaceID] = t.record
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Found %d validation errors:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// validateTask returns all validation errors of the task.
+func validateTask(t *Task) []error {
+	var errs []error
+
+	if t.bucket == "" {
+		errs = append(errs, errors.New("Missing bucket"))
+	}
+	if t.key == "" {
+		errs = append(errs, errors.New("Missing key"))
+	}
+	if t.organization == "" {
+		errs = append(errs, errors.New("Missing organization (use -organization or the organization column)"))
+	}
+	if t.workspace == "" && t.workspaceID == "" && !isPrefix(t.key) && !t.workspaceFromBackend {
+		errs = append(errs, errors.New("Missing workspace or workspace ID"))
+	}
+	if t.workspace != "" && t.workspaceID != "" {
+		errs = append(errs, errors.New("Only one of workspace and workspace ID can be set"))
+	}
+	if t.workspaceID != "" && isPrefix(t.key) {
+		errs = append(errs, errors.New("A workspace ID cannot be used with a key prefix"))
+	}
+	if t.workspace != "" && !isPrefix(t.key) && !validWorkspaceName(t.workspace) {
+		errs = append(errs, fmt.Errorf(
+			"Invalid workspace name %q, it can only contain letters, numbers, - and _ "+
+				"(use -name-replace to replace other characters) and must be at most %d characters",
+			t.workspace, maxWorkspaceNameLength,
+		))
+	}
+	if t.source != s3Source && t.source != gcsSource && t.source != tfeSource {
+		errs = append(errs, fmt.Errorf("Unsupported state source %q", t.source))
+	}
+	if t.vcs != "" && !validVCS(t.vcs) {
+		errs = append(errs, fmt.Errorf("Unsupported VCS provider %q", t.vcs))
+	}
+	if t.executionMode != "" && !validExecutionMode(t.executionMode) {
+		errs = append(errs, fmt.Errorf("Invalid execution mode %q", t.executionMode))
+	}
+	if t.terraformVersion != "" && !validVersion(t.terraformVersion) {
+		errs = append(errs, fmt.Errorf("Invalid Terraform version %q, expected a version like 1.5.7", t.terraformVersion))
+	}
+	if t.workingDirectory != "" && !relativePath(t.workingDirectory) {
+		errs = append(errs, fmt.Errorf("Working directory %q is not a relative path", t.workingDirectory))
+	}
+	for _, prefix := range t.triggerPrefixes {
+		if !relativePath(prefix) {
+			errs = append(errs, fmt.Errorf("Trigger prefix %q is not a relative path", prefix))
+		}
+	}
+
+	return errs
+}
+
+// maxWorkspaceNameLength is the maximum length of a workspace name.
+const maxWorkspaceNameLength = 90
+
+// validWorkspaceName reports whether name is a valid TFE workspace name.
+func validWorkspaceName(name string) bool {
+	if name == "" || len(name) > maxWorkspaceNameLength {
+		return false
+	}
+	for _, c := range name {
+		if !validWorkspaceChar(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validWorkspaceChar reports whether c is allowed in a TFE workspace name.
+func validWorkspaceChar(c rune) bool {
+	return c == '-' || c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// normalizeWorkspaceName replaces every character of name that is not allowed
+// in a TFE workspace name by the replacement.
+func normalizeWorkspaceName(name, replacement string) string {
+	var b strings.Builder
+	for _, c := range name {
+		if validWorkspaceChar(c) {
+			b.WriteRune(c)
+		} else {
+			b.WriteString(replacement)
+		}
+	}
+	return b.String()
+}
+
+// relativePath reports whether p is a path relative to, and within, the root
+// of a repository.
+func relativePath(p string) bool {
+	if path.IsAbs(p) {
+		return false
+	}
+	p = path.Clean(p)
+	return p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// inputFormat returns the format of the input file. Unless a format is given
+// explicitly, files with a .json extension are read as JSON.
+func inputFormat(path, format string) (string, error) {
+	switch strings.ToLower(format) {
+	case csvFormat:
+		return csvFormat, nil
+	case jsonFormat:
+		return jsonFormat, nil
+	case "":
+		if strings.ToLower(filepath.Ext(path)) == ".json" {
+			return jsonFormat, nil
+		}
+		return csvFormat, nil
+	default:
+		return "", fmt.Errorf("Invalid input format: %s", format)
+	}
+}
+
+// openInput opens the input file, or returns stdin if path is "-".
+func openInput(path string) (io.ReadCloser, error) {
+	if path == stdinInput {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// stdinIsPipe reports whether stdin is a pipe or file instead of a terminal.
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice == 0
+}
+
+// parseList parses a comma or semicolon separated list, like a list of tags.
+func parseList(s string) []string {
+	var items []string
+
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ';' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// isHeader reports whether the record is a header, which is the case when most
+// of its fields are known column names. Requiring a majority prevents a data
+// row that happens to contain a column name (like a workspace named
+// "workspace") from being mistaken for a header, while a header with a typo
+// is still parsed as a header so parseHeader can report the unknown column.
+func isHeader(record []string) bool {
+	known := 0
+	for _, field := range record {
+		if columnName(field) != "" {
+			known++
+		}
+	}
+	return known*2 > len(record)
+}
+
+// parseHeader maps each column name in the header to its index.
+func parseHeader(header []string) (map[string]int, error) {
+	columns := make(map[string]int, len(header))
+
+	for i, field := range header {
+		name := columnName(field)
+		if name == "" {
+			return nil, fmt.Errorf("Unknown column %q in header", field)
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("Duplicate column %q in header", field)
+		}
+		columns[name] = i
+	}
+
+	for _, name := range requiredColumns {
+		// Just like in JSON input, the workspace is validated per task,
+		// as it's not needed when using a workspace ID, a key prefix or
+		// -workspace-from-backend.
+		if name == workspaceColumn {
+			continue
+		}
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("Missing required column %q in header", name)
+		}
+	}
+
+	return columns, nil
+}
+
+// defaultColumns returns the positional column mapping used for input files
+// without a header.
+func defaultColumns() map[string]int {
+	columns := make(map[string]int, len(requiredColumns))
+	for i, name := range requiredColumns {
+		columns[name] = i
+	}
+	return columns
+}
+
+// columnName returns the canonical name of the column matching the given
+// header field, or an empty string if the field is not a known column.
+func columnName(field string) string {
+	field = strings.TrimSpace(field)
+	for _, name := range requiredColumns {
+		if strings.EqualFold(field, name) {
+			return name
+		}
+	}
+	for _, name := range optionalColumns {
+		if strings.EqualFold(field, name) {
+			return name
+		}
+	}
+	return ""
+}
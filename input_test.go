@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsHeader(t *testing.T) {
+	cases := []struct {
+		record []string
+		header bool
+	}{
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile", "workspace"}, true},
+		{[]string{"workspace", "key", "bucket"}, true},
+		{[]string{" Bucket ", "KEY", "workspace_id"}, true},
+		{[]string{"my-bucket", "app.tfstate", "PRJ", "repo", "master", "main.tf", "app"}, false},
+		{[]string{"my-bucket", "app.tfstate", "PRJ", "repo", "master", "main.tf", "workspace"}, false},
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile", "typo"}, true},
+		{[]string{"bucket", "key", "nope", "typo"}, false},
+		{[]string{}, false},
+	}
+
+	for _, c := range cases {
+		if got := isHeader(c.record); got != c.header {
+			t.Errorf("isHeader(%q) = %t, want %t", c.record, got, c.header)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	cases := []struct {
+		header []string
+		err    string
+	}{
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile", "workspace"}, ""},
+		{[]string{"workspace_id", "configFile", "branch", "repo", "project", "key", "bucket"}, ""},
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile"}, ""},
+		{[]string{"bucket", "project", "repo", "branch", "configFile", "workspace"}, `Missing required column "key"`},
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile", "Key"}, `Duplicate column "Key"`},
+		{[]string{"bucket", "key", "project", "repo", "branch", "configFile", "nope"}, `Unknown column "nope"`},
+	}
+
+	for _, c := range cases {
+		columns, err := parseHeader(c.header)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("parseHeader(%q) returned error %v, want %q", c.header, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHeader(%q) returned unexpected error: %v", c.header, err)
+			continue
+		}
+		for i, field := range c.header {
+			if columns[field] != i {
+				t.Errorf("parseHeader(%q) maps %q to %d, want %d", c.header, field, columns[field], i)
+			}
+		}
+	}
+}
+
+func TestReadTasks(t *testing.T) {
+	cases := []struct {
+		name       string
+		input      string
+		workspaces []string
+	}{
+		{
+			name:       "without header",
+			input:      "b,app.tfstate,PRJ,repo,master,main.tf,app\nb,db.tfstate,PRJ,repo,master,main.tf,db\n",
+			workspaces: []string{"app", "db"},
+		},
+		{
+			name:       "with header",
+			input:      "workspace,bucket,key,project,repo,branch,configFile\napp,b,app.tfstate,PRJ,repo,master,main.tf\n",
+			workspaces: []string{"app"},
+		},
+		{
+			name:       "workspace named like a column",
+			input:      "b,app.tfstate,PRJ,repo,master,main.tf,workspace\n",
+			workspaces: []string{"workspace"},
+		},
+		{
+			name:       "header without workspace",
+			input:      "bucket,key,project,repo,branch,configFile,workspace_id\nb,app.tfstate,PRJ,repo,master,main.tf,ws-123\n",
+			workspaces: []string{""},
+		},
+	}
+
+	for _, c := range cases {
+		tasks, _, err := readTasks(strings.NewReader(c.input), false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if len(tasks) != len(c.workspaces) {
+			t.Errorf("%s: got %d tasks, want %d", c.name, len(tasks), len(c.workspaces))
+			continue
+		}
+		for i, task := range tasks {
+			if task.workspace != c.workspaces[i] {
+				t.Errorf("%s: task %d has workspace %q, want %q", c.name, i, task.workspace, c.workspaces[i])
+			}
+			if task.bucket != "b" || task.configFile != "main.tf" {
+				t.Errorf("%s: task %d has bucket %q and config file %q", c.name, i, task.bucket, task.configFile)
+			}
+		}
+	}
+}
+
+func TestReadTasksHeaderTypo(t *testing.T) {
+	input := "bucket,key,project,repo,branch,cofnigFile,workspace\nb,app.tfstate,PRJ,repo,master,main.tf,app\n"
+
+	_, _, err := readTasks(strings.NewReader(input), false)
+	if err == nil || !strings.Contains(err.Error(), `Unknown column "cofnigFile"`) {
+		t.Fatalf("expected the unknown column to be reported, got: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// local implements ConfigStore by reading and writing config files on disk.
+// The config file of a task is expected at <root>/<repo>/<configFile>. No
+// commits are made, so the changes can be reviewed and committed manually.
+type local struct {
+	root string
+}
+
+// LatestCommit implements ConfigStore. Local files are not committed, so
+// there is no commit ID to return.
+func (l *local) LatestCommit(ctx context.Context, t *Task) (string, error) {
+	return "", nil
+}
+
+// Read implements ConfigStore.
+func (l *local) Read(ctx context.Context, t *Task) (string, error) {
+	content, err := ioutil.ReadFile(l.path(t))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Write implements ConfigStore.
+func (l *local) Write(ctx context.Context, t *Task, content, message string) error {
+	path := l.path(t)
+
+	// Keep the permissions of the existing file.
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, []byte(content), fi.Mode().Perm())
+}
+
+// path returns the path of the config file of the task.
+func (l *local) path(t *Task) string {
+	return filepath.Join(l.root, t.repo, filepath.FromSlash(t.configFile))
+}
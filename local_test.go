@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestLocalUpdateBackend(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "repo", "envs", "prod", "main.tf")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `terraform {
+  required_version = ">= 0.12"
+
+  backend "s3" {
+    bucket = "states"
+    key    = "prod/app.tfstate"
+  }
+}
+
+resource "null_resource" "app" {}
+`
+	if err := ioutil.WriteFile(path, []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Migrator{
+		stores:         map[string]ConfigStore{localVCS: &local{root: root}},
+		repoLocks:      make(map[string]chan struct{}),
+		hostname:       "tfe.example.com",
+		commitTemplate: template.Must(parseCommitMessage(defaultCommitMessage)),
+	}
+	task := &Task{
+		vcs:          localVCS,
+		repo:         "repo",
+		configFile:   "envs/prod/main.tf",
+		organization: "org",
+		workspace:    "app-prod",
+	}
+
+	if err := m.updateBackend(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error updating the backend: %v", err)
+	}
+
+	want := `terraform {
+  required_version = ">= 0.12"
+
+  backend "remote" {
+    hostname     = "tfe.example.com"
+    organization = "org"
+
+    workspaces {
+      name = "app-prod"
+    }
+  }
+}
+
+resource "null_resource" "app" {}
+`
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Fatalf("unexpected config file:\n%s\nwant:\n%s", content, want)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("expected the permissions of the config file to be kept, got %v", fi.Mode().Perm())
+	}
+
+	// Updating the backend again leaves the config file alone.
+	if err := m.updateBackend(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error updating the backend again: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != want {
+		t.Fatalf("expected the config file to be unchanged, got:\n%s", content)
+	}
+}
+
+func TestLocalCreateBackend(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "repo", "main.tf")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `resource "null_resource" "app" {}`
+	if err := ioutil.WriteFile(path, []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Migrator{
+		stores:         map[string]ConfigStore{localVCS: &local{root: root}},
+		repoLocks:      make(map[string]chan struct{}),
+		hostname:       "tfe.example.com",
+		commitTemplate: template.Must(parseCommitMessage(defaultCommitMessage)),
+	}
+	task := &Task{
+		vcs:          localVCS,
+		repo:         "repo",
+		configFile:   "main.tf",
+		organization: "org",
+		workspace:    "app",
+	}
+
+	// Without -create-backend a config file without a terraform block
+	// fails the task.
+	err := m.updateBackend(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), "use -create-backend to add one") {
+		t.Fatalf("expected the missing terraform block to be reported, got: %v", err)
+	}
+
+	m.createBackend = true
+	if err := m.updateBackend(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error creating the backend: %v", err)
+	}
+
+	want := `resource "null_resource" "app" {}
+
+terraform {
+  backend "remote" {
+    hostname     = "tfe.example.com"
+    organization = "org"
+
+    workspaces {
+      name = "app"
+    }
+  }
+}
+`
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != want {
+		t.Fatalf("unexpected config file:\n%s\nwant:\n%s", content, want)
+	}
+}
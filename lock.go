@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// The DynamoDB API version used for all requests.
+const dynamoDBTargetPrefix = "DynamoDB_20120810."
+
+// stateLocker locks the states in S3 using a DynamoDB table, in the same way
+// as the S3 backend of Terraform does. So while a state is being migrated,
+// Terraform can't acquire a lock to write to the same state.
+//
+// The vendored AWS SDK does not include the DynamoDB client, so the few API
+// calls that are needed are made directly.
+type stateLocker struct {
+	clients *s3Clients
+	table   string
+}
+
+// lockInfo is the lock information stored with the lock, using the format
+// of Terraform so it's shown when Terraform fails to acquire the lock.
+type lockInfo struct {
+	ID        string
+	Operation string
+	Info      string
+	Who       string
+	Version   string
+	Created   time.Time
+	Path      string
+}
+
+// dynamoDBError is the error returned by the DynamoDB API.
+type dynamoDBError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// Error implements error.
+func (e *dynamoDBError) Error() string {
+	return fmt.Sprintf("%s: %s", e.code(), e.Message)
+}
+
+// code returns the error code, without the service prefix.
+func (e *dynamoDBError) code() string {
+	return e.Type[strings.LastIndex(e.Type, "#")+1:]
+}
+
+// lock acquires the lock of the state of the task and returns a function
+// that releases the lock. If the state is already locked, an error is
+// returned.
+func (l *stateLocker) lock(ctx context.Context, t *Task) (func(context.Context) error, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	path := t.bucket + "/" + t.key
+	info, err := json.Marshal(&lockInfo{
+		ID:        hex.EncodeToString(id),
+		Operation: "migration",
+		Info:      "Migrating the state to Terraform Enterprise",
+		Who:       lockOwner(),
+		Created:   time.Now().UTC(),
+		Path:      path,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = l.do(ctx, t, "PutItem", map[string]interface{}{
+		"TableName": l.table,
+		"Item": map[string]interface{}{
+			"LockID": map[string]string{"S": path},
+			"Info":   map[string]string{"S": string(info)},
+		},
+		"ConditionExpression": "attribute_not_exists(LockID)",
+	}, nil)
+	if e, ok := err.(*dynamoDBError); ok && e.code() == "ConditionalCheckFailedException" {
+		return nil, l.lockedError(ctx, t, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := func(ctx context.Context) error {
+		// Only delete the lock when it's still ours.
+		return l.do(ctx, t, "DeleteItem", map[string]interface{}{
+			"TableName":           l.table,
+			"Key":                 map[string]interface{}{"LockID": map[string]string{"S": path}},
+			"ConditionExpression": "Info = :info",
+			"ExpressionAttributeValues": map[string]interface{}{
+				":info": map[string]string{"S": string(info)},
+			},
+		}, nil)
+	}
+
+	return unlock, nil
+}
+
+// lockedError returns an error describing the lock that is already held on
+// the state, if it can be read.
+func (l *stateLocker) lockedError(ctx context.Context, t *Task, path string) error {
+	var response struct {
+		Item struct {
+			Info struct {
+				S string
+			}
+		}
+	}
+
+	err := l.do(ctx, t, "GetItem", map[string]interface{}{
+		"TableName":      l.table,
+		"Key":            map[string]interface{}{"LockID": map[string]string{"S": path}},
+		"ConsistentRead": true,
+	}, &response)
+	if err != nil {
+		return fmt.Errorf("state s3://%s is locked", path)
+	}
+
+	var info lockInfo
+	if err := json.Unmarshal([]byte(response.Item.Info.S), &info); err != nil {
+		return fmt.Errorf("state s3://%s is locked", path)
+	}
+
+	return fmt.Errorf(
+		"state s3://%s is locked (lock ID %s, operation %q, held by %s since %s)",
+		path, info.ID, info.Operation, info.Who, info.Created.Format(time.RFC3339),
+	)
+}
+
+// do makes a DynamoDB API call, using the credentials of the role of the
+// task. The body is JSON encoded and, if v is not nil, the response is JSON
+// decoded into v.
+func (l *stateLocker) do(ctx context.Context, t *Task, action string, body, v interface{}) error {
+	region := aws.StringValue(l.clients.sess.Config.Region)
+	if region == "" {
+		return errors.New("missing AWS region")
+	}
+
+	endpoint, err := endpoints.DefaultResolver().EndpointFor(endpoints.DynamodbServiceID, region)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", endpoint.URL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.0")
+	req.Header.Set("X-Amz-Target", dynamoDBTargetPrefix+action)
+	req.ContentLength = int64(len(payload))
+
+	// Signing the request also sets the body of the request.
+	signer := v4.NewSigner(l.clients.credentials(t.roleARN))
+	_, err = signer.Sign(req, bytes.NewReader(payload), endpoint.SigningName, endpoint.SigningRegion, time.Now())
+	if err != nil {
+		return err
+	}
+
+	client := l.clients.sess.Config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		e := &dynamoDBError{}
+		data, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, e); err != nil || e.Type == "" {
+			return fmt.Errorf("unexpected response from DynamoDB: %s", resp.Status)
+		}
+		return e
+	}
+
+	if v != nil {
+		return json.NewDecoder(resp.Body).Decode(v)
+	}
+
+	return nil
+}
+
+// lockOwner returns who holds the lock, in the same format as Terraform.
+func lockOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return name + "@" + host
+}
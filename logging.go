@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// newLogger returns a logger writing to w using the given level (debug, info,
+// warn or error) and format (text or json).
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("Invalid log level: %s", level)
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("Invalid log format: %s", format)
+	}
+}
+
+// logger returns a logger that adds the workspace and the state key of the
+// task to every log line.
+func (t *Task) logger() *slog.Logger {
+	return slog.With("workspace", t.workspace, "key", t.key)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"text/template"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// fakeDownloader is a StateDownloader returning the states by key.
+type fakeDownloader map[string][]byte
+
+// Download implements StateDownloader.
+func (d fakeDownloader) Download(ctx context.Context, t *Task) ([]byte, error) {
+	return d[t.key], nil
+}
+
+func TestDownloadStateMalformed(t *testing.T) {
+	m := &Migrator{
+		downloaders: map[string]StateDownloader{
+			s3Source: fakeDownloader{
+				"truncated.tfstate": []byte(`{"version": 3, "serial": 4, "lineage": "abc`),
+				"array.tfstate":     []byte(`["version", 3]`),
+				"text.tfstate":      []byte(`not a state`),
+			},
+		},
+	}
+
+	for _, key := range []string{"truncated.tfstate", "array.tfstate", "text.tfstate"} {
+		task := &Task{source: s3Source, bucket: "b", key: key, meta: &Meta{}}
+
+		err := m.downloadState(context.Background(), task)
+		if err == nil {
+			t.Errorf("%s: expected an error for a malformed state", key)
+			continue
+		}
+		if !strings.Contains(err.Error(), "Failed to parse the state file") || !strings.Contains(err.Error(), key) {
+			t.Errorf("%s: expected a parse error naming the key, got: %v", key, err)
+		}
+	}
+}
+
+func TestCreateWorkspace(t *testing.T) {
+	f := &fakeTFE{
+		workspaces: map[string]*tfe.Workspace{
+			"migrated": {ID: "ws-migrated", Name: "migrated"},
+			"empty":    {ID: "ws-empty", Name: "empty"},
+		},
+		states: map[string][]*fakeStateVersion{
+			"ws-migrated": {{serial: 1}},
+		},
+	}
+	m := newFakeTFE(t, f)
+	m.execMode = "local"
+
+	cases := []struct {
+		workspace string
+		created   bool
+		updated   bool
+	}{
+		// A new workspace is created and configured.
+		{"new", true, true},
+		// An existing workspace with a state is left alone.
+		{"migrated", false, false},
+		// An existing workspace without a state is configured again, so
+		// its migration is completed.
+		{"empty", true, true},
+	}
+
+	for _, c := range cases {
+		f.updates = nil
+		task := &Task{organization: "org", workspace: c.workspace, version: "0.11.14"}
+
+		w, created, err := m.createWorkspace(context.Background(), task)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.workspace, err)
+			continue
+		}
+		if w.ID != "ws-"+c.workspace {
+			t.Errorf("%s: unexpected workspace %q", c.workspace, w.ID)
+		}
+		if created != c.created {
+			t.Errorf("%s: expected created to be %t", c.workspace, c.created)
+		}
+		if updated := len(f.updates) > 0; updated != c.updated {
+			t.Errorf("%s: expected the workspace to be updated: %t, got updates %q", c.workspace, c.updated, f.updates)
+		}
+	}
+
+	if len(f.created) != 1 || *f.created[0].Name != "new" || *f.created[0].TerraformVersion != "0.11.14" {
+		t.Errorf("expected only the new workspace to be created, got %+v", f.created)
+	}
+}
+
+func TestCreateWorkspaceErrors(t *testing.T) {
+	boom := errors.New("boom")
+
+	cases := []struct {
+		name string
+		f    *fakeTFE
+		err  string
+	}{
+		{
+			name: "read",
+			f:    &fakeTFE{readErr: boom},
+			err:  "boom",
+		},
+		{
+			name: "create",
+			f:    &fakeTFE{createErr: boom},
+			err:  "boom",
+		},
+		{
+			name: "current state",
+			f: &fakeTFE{
+				workspaces: map[string]*tfe.Workspace{"app": {ID: "ws-app", Name: "app"}},
+				currentErr: boom,
+			},
+			err: "Failed to read the current state: boom",
+		},
+	}
+
+	for _, c := range cases {
+		m := newFakeTFE(t, c.f)
+		task := &Task{organization: "org", workspace: "app"}
+
+		w, created, err := m.createWorkspace(context.Background(), task)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected error %q, got: %v", c.name, c.err, err)
+		}
+		if w != nil || created {
+			t.Errorf("%s: expected no workspace, got %+v (created: %t)", c.name, w, created)
+		}
+	}
+}
+
+func TestUploadState(t *testing.T) {
+	f := &fakeTFE{
+		states: map[string][]*fakeStateVersion{
+			"ws-app": {{serial: 5, lineage: "abc"}},
+		},
+	}
+	m := newFakeTFE(t, f)
+	m.verify = true
+
+	state := []byte(`{"version": 3, "serial": 7, "lineage": "abc"}`)
+	task := &Task{
+		organization: "org",
+		workspace:    "app",
+		state:        state,
+		meta:         &Meta{Serial: 7, Lineage: "abc"},
+		history: []*stateVersion{
+			{state: []byte(`{"serial": 4}`), meta: &Meta{Serial: 4, Lineage: "abc"}},
+			{state: []byte(`{"serial": 6}`), meta: &Meta{Serial: 6, Lineage: "abc"}},
+		},
+	}
+
+	if err := m.uploadState(context.Background(), task, &tfe.Workspace{ID: "ws-app"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The version older than the current state is skipped.
+	states := f.states["ws-app"]
+	if len(states) != 3 || states[1].serial != 6 || states[2].serial != 7 {
+		t.Fatalf("expected the state versions with serial 6 and 7 to be uploaded, got %d versions", len(states))
+	}
+	if string(states[2].state) != string(state) || states[2].lineage != "abc" {
+		t.Errorf("unexpected state uploaded: %+v", states[2])
+	}
+	if sum := fmt.Sprintf("%x", md5.Sum(state)); states[2].md5 != sum {
+		t.Errorf("expected MD5 %s, got %s", sum, states[2].md5)
+	}
+}
+
+func TestUploadStateRollback(t *testing.T) {
+	f := &fakeTFE{
+		states: map[string][]*fakeStateVersion{
+			"ws-app": {{serial: 5, lineage: "abc"}},
+		},
+	}
+	m := newFakeTFE(t, f)
+	task := &Task{
+		organization: "org",
+		workspace:    "app",
+		state:        []byte(`{"serial": 5}`),
+		meta:         &Meta{Serial: 5, Lineage: "abc"},
+	}
+	w := &tfe.Workspace{ID: "ws-app"}
+
+	err := m.uploadState(context.Background(), task, w)
+	if err == nil || !strings.Contains(err.Error(), "Refusing to upload state with serial 5") {
+		t.Fatalf("expected the upload to be refused, got: %v", err)
+	}
+	if len(f.states["ws-app"]) != 1 {
+		t.Fatalf("expected no state to be uploaded")
+	}
+
+	// The state is uploaded anyway when forced.
+	m.force = true
+	if err := m.uploadState(context.Background(), task, w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(f.states["ws-app"]) != 2 {
+		t.Fatalf("expected the state to be uploaded when forced")
+	}
+}
+
+func TestUploadStateRetry(t *testing.T) {
+	f := &fakeTFE{lostUploads: 1}
+	m := newFakeTFE(t, f)
+	m.maxRetries = 1
+
+	state := []byte(`{"version": 3, "serial": 1, "lineage": "abc"}`)
+	task := &Task{
+		organization: "org",
+		workspace:    "app",
+		state:        state,
+		meta:         &Meta{Serial: 1, Lineage: "abc"},
+	}
+
+	// The first upload is stored, but fails with a server error, so it's
+	// not created again when retried.
+	if err := m.uploadState(context.Background(), task, &tfe.Workspace{ID: "ws-app"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if states := f.states["ws-app"]; len(states) != 1 {
+		t.Fatalf("expected a single state version to be created, got %d versions", len(states))
+	}
+}
+
+func TestUploadStateErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		f    *fakeTFE
+		err  string
+	}{
+		{
+			name: "current state",
+			f:    &fakeTFE{currentErr: errors.New("boom")},
+			err:  "Failed to read the current state: boom",
+		},
+		{
+			name: "upload",
+			f:    &fakeTFE{uploadCode: 422},
+			err:  "upload failed",
+		},
+	}
+
+	for _, c := range cases {
+		m := newFakeTFE(t, c.f)
+		task := &Task{
+			organization: "org",
+			workspace:    "app",
+			state:        []byte(`{"serial": 1}`),
+			meta:         &Meta{Serial: 1, Lineage: "abc"},
+		}
+
+		err := m.uploadState(context.Background(), task, &tfe.Workspace{ID: "ws-app"})
+		if err == nil || err.Error() != c.err {
+			t.Errorf("%s: expected error %q, got: %v", c.name, c.err, err)
+		}
+	}
+
+	// Errors uploading a previous version name its serial.
+	m := newFakeTFE(t, &fakeTFE{uploadCode: 422})
+	task := &Task{
+		organization: "org",
+		workspace:    "app",
+		state:        []byte(`{"serial": 2}`),
+		meta:         &Meta{Serial: 2, Lineage: "abc"},
+		history:      []*stateVersion{{state: []byte(`{"serial": 1}`), meta: &Meta{Serial: 1, Lineage: "abc"}}},
+	}
+
+	err := m.uploadState(context.Background(), task, &tfe.Workspace{ID: "ws-app"})
+	if err == nil || err.Error() != "Failed to upload state version with serial 1: upload failed" {
+		t.Errorf("expected the upload of the previous version to fail, got: %v", err)
+	}
+}
+
+func TestUpdateBackendStaleBranch(t *testing.T) {
+	config := "terraform {\n  backend \"s3\" {}\n}\n"
+	f := &fakeBitbucket{
+		commits: []string{"c1"},
+		files:   map[string]string{"c1": config},
+	}
+
+	// Someone else changes the config file while it's committed the first
+	// time, so the commit conflicts.
+	pushed := false
+	f.beforeWrite = func() {
+		if !pushed {
+			f.push(config + "\nresource \"null_resource\" \"other\" {}\n")
+			pushed = true
+		}
+	}
+
+	m := &Migrator{
+		stores:         map[string]ConfigStore{bitbucketVCS: newFakeBitbucket(t, f)},
+		repoLocks:      make(map[string]chan struct{}),
+		commitTemplate: template.Must(parseCommitMessage(defaultCommitMessage)),
+	}
+	task := &Task{
+		vcs:          bitbucketVCS,
+		project:      "PRJ",
+		repo:         "repo",
+		branch:       "master",
+		configFile:   "main.tf",
+		organization: "org",
+		workspace:    "app",
+	}
+
+	if err := m.updateBackend(context.Background(), task); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The config file is read and rewritten again, so the commit is on top
+	// of the other change and keeps it.
+	if len(f.writes) != 2 || f.writes[1].Get("sourceCommitId") != "c2" {
+		t.Fatalf("expected the config file to be committed again on top of c2, got %v", f.writes)
+	}
+	content := f.files[f.latest()]
+	if !strings.Contains(content, `backend "remote"`) || !strings.Contains(content, `"other"`) {
+		t.Fatalf("expected the rewritten config file to keep the other change, got:\n%s", content)
+	}
+}
+
+func TestUpdateBackendStaleBranchRetries(t *testing.T) {
+	config := "terraform {\n  backend \"s3\" {}\n}\n"
+	f := &fakeBitbucket{
+		commits: []string{"c1"},
+		files:   map[string]string{"c1": config},
+	}
+
+	// Someone else changes the config file every time it's committed.
+	f.beforeWrite = func() {
+		f.push(fmt.Sprintf("%s\n# change %d\n", config, len(f.commits)))
+	}
+
+	m := &Migrator{
+		stores:         map[string]ConfigStore{bitbucketVCS: newFakeBitbucket(t, f)},
+		repoLocks:      make(map[string]chan struct{}),
+		commitTemplate: template.Must(parseCommitMessage(defaultCommitMessage)),
+	}
+	task := &Task{
+		vcs:          bitbucketVCS,
+		project:      "PRJ",
+		repo:         "repo",
+		branch:       "master",
+		configFile:   "main.tf",
+		organization: "org",
+		workspace:    "app",
+	}
+
+	err := m.updateBackend(context.Background(), task)
+	if err == nil || !strings.Contains(err.Error(), "as its branch was updated 4 times while committing") {
+		t.Fatalf("expected the commit to conflict every time, got: %v", err)
+	}
+	if len(f.writes) != maxCommitConflictRetries+1 {
+		t.Fatalf("expected %d commits, got %d", maxCommitConflictRetries+1, len(f.writes))
+	}
+}
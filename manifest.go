@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// manifestEntry maps the source state of a migrated task to the workspace it
+// is migrated to and the commit that updated its backend configuration.
+type manifestEntry struct {
+	Source       string `json:"source"`
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	Lineage      string `json:"lineage"`
+	Serial       int64  `json:"serial"`
+	Organization string `json:"organization"`
+	Workspace    string `json:"workspace"`
+	WorkspaceID  string `json:"workspace_id,omitempty"`
+	VCS          string `json:"vcs,omitempty"`
+	Project      string `json:"project,omitempty"`
+	Repo         string `json:"repo,omitempty"`
+	Branch       string `json:"branch,omitempty"`
+	ConfigFile   string `json:"config_file,omitempty"`
+	CommitID     string `json:"commit_id,omitempty"`
+	CommitBranch string `json:"commit_branch,omitempty"`
+	PullRequest  string `json:"pull_request,omitempty"`
+}
+
+// writeManifest writes a JSON manifest with an entry for every task whose
+// state is migrated to the given path. Partial tasks are included, as their
+// state is migrated, but they have no commit.
+func writeManifest(path string, results []*Result, noBackendUpdate bool) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := []*manifestEntry{}
+	for _, r := range results {
+		if r.Status != statusSucceeded && r.Status != statusPartial {
+			continue
+		}
+
+		t := r.task
+		entry := &manifestEntry{
+			Source:       t.source,
+			Bucket:       t.bucket,
+			Key:          t.key,
+			Lineage:      t.meta.Lineage,
+			Serial:       t.meta.Serial,
+			Organization: t.organization,
+			Workspace:    t.workspace,
+			WorkspaceID:  t.migratedWorkspaceID,
+		}
+		if !noBackendUpdate {
+			entry.VCS = t.vcs
+			entry.Project = t.project
+			entry.Repo = t.repo
+			entry.Branch = t.branch
+			entry.ConfigFile = t.configFile
+			entry.CommitID = t.commitID
+			entry.CommitBranch = t.commitBranch
+			entry.PullRequest = t.pullRequestURL
+		}
+		entries = append(entries, entry)
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// readMeta reads the metadata of the state from r. Only the top-level fields
+// are decoded and the values of all other fields (like the resources) are
+// skipped token by token, so large states are never fully decoded. Reading
+// stops as soon as all metadata fields are found.
+func readMeta(r io.Reader, meta *Meta) error {
+	dec := json.NewDecoder(r)
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	found := 0
+	for found < 3 && dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case "lineage":
+			err = dec.Decode(&meta.Lineage)
+			found++
+		case "serial":
+			err = dec.Decode(&meta.Serial)
+			found++
+		case "terraform_version":
+			err = dec.Decode(&meta.TerraformVersion)
+			found++
+		default:
+			err = skipValue(dec)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expectDelim reads the next token and checks that it's the given delimiter.
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+// skipValue skips the next value, without decoding any nested objects or
+// arrays as a whole.
+func skipValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
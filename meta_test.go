@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// largeState returns a synthetic state with the given number of resources.
+// The metadata is written after the resources when metaLast is set, like in
+// states written by Terraform 0.11.
+func largeState(resources int, metaLast bool) []byte {
+	var buf bytes.Buffer
+
+	meta := `"version": 4, "terraform_version": "1.5.7", "serial": 42, "lineage": "0f2c4d1e-lineage"`
+	buf.WriteString("{")
+	if !metaLast {
+		buf.WriteString(meta + ", ")
+	}
+	buf.WriteString(`"resources": [`)
+	for i := 0; i < resources; i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"type": "aws_instance", "name": "i%d", "instances": [{"attributes": {"id": "i-%d", "tags": {"lineage": "not-this-one", "serial": "1"}, "user_data": "#!/bin/sh\necho {\"serial\": 1}"}}]}`, i, i)
+	}
+	buf.WriteString("]")
+	if metaLast {
+		buf.WriteString(", " + meta)
+	}
+	buf.WriteString("}")
+
+	return buf.Bytes()
+}
+
+func TestReadMeta(t *testing.T) {
+	for _, metaLast := range []bool{false, true} {
+		meta := &Meta{}
+		if err := readMeta(bytes.NewReader(largeState(1000, metaLast)), meta); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if meta.Lineage != "0f2c4d1e-lineage" || meta.Serial != 42 || meta.TerraformVersion != "1.5.7" {
+			t.Errorf("unexpected metadata with the metadata last %t: %+v", metaLast, meta)
+		}
+	}
+}
+
+func TestReadMetaMissingFields(t *testing.T) {
+	meta := &Meta{}
+	if err := readMeta(strings.NewReader(`{"version": 4, "serial": 3, "resources": []}`), meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Lineage != "" || meta.Serial != 3 || meta.TerraformVersion != "" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+}
+
+// failingReader fails the test when it's read.
+type failingReader struct {
+	t *testing.T
+}
+
+func (r failingReader) Read(p []byte) (int, error) {
+	r.t.Fatal("read past the metadata of the state")
+	return 0, io.EOF
+}
+
+func TestReadMetaStopsEarly(t *testing.T) {
+	// Everything after the metadata fails when read, so the resources
+	// must not be read at all.
+	state := io.MultiReader(
+		strings.NewReader(`{"version": 4, "terraform_version": "1.5.7", "serial": 42, "lineage": "abc", "resources": [`),
+		failingReader{t},
+	)
+
+	meta := &Meta{}
+	if err := readMeta(state, meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.Lineage != "abc" || meta.Serial != 42 || meta.TerraformVersion != "1.5.7" {
+		t.Errorf("unexpected metadata: %+v", meta)
+	}
+}
+
+// The benchmarks compare reading the metadata of a large state with
+// unmarshaling the whole state, run them with -benchmem to compare the
+// memory used as well.
+
+func BenchmarkReadMeta(b *testing.B) {
+	state := largeState(20000, true)
+	b.SetBytes(int64(len(state)))
+
+	for i := 0; i < b.N; i++ {
+		if err := readMeta(bytes.NewReader(state), &Meta{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalState(b *testing.B) {
+	state := largeState(20000, true)
+	b.SetBytes(int64(len(state)))
+
+	for i := 0; i < b.N; i++ {
+		var v map[string]interface{}
+		if err := json.Unmarshal(state, &v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// phaseBuckets are the upper bounds in seconds of the phase duration
+// histogram buckets.
+var phaseBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// metrics collects the migration metrics and serves them in the Prometheus
+// text format. It's safe to use concurrently.
+type metrics struct {
+	mu        sync.Mutex
+	total     int
+	inFlight  int
+	completed map[string]int
+	phases    map[string]*histogram
+}
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	counts []int
+	count  int
+	sum    float64
+}
+
+// newMetrics returns the metrics for the given number of tasks.
+func newMetrics(total int) *metrics {
+	m := &metrics{
+		total:     total,
+		completed: make(map[string]int),
+		phases:    make(map[string]*histogram),
+	}
+	for _, phase := range phases {
+		m.phases[phase] = &histogram{counts: make([]int, len(phaseBuckets))}
+	}
+	return m
+}
+
+// taskStarted records that a task is started.
+func (m *metrics) taskStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+}
+
+// taskDone records the status and the phase durations of a finished task.
+func (m *metrics) taskDone(status string, timings map[string]time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.inFlight--
+	m.completed[status]++
+
+	for phase, d := range timings {
+		h, ok := m.phases[phase]
+		if !ok {
+			continue
+		}
+		seconds := d.Seconds()
+		for i, le := range phaseBuckets {
+			if seconds <= le {
+				h.counts[i]++
+			}
+		}
+		h.count++
+		h.sum += seconds
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// write writes the metrics in the Prometheus text format.
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP tf_tfe_tasks The number of tasks to migrate.")
+	fmt.Fprintln(w, "# TYPE tf_tfe_tasks gauge")
+	fmt.Fprintf(w, "tf_tfe_tasks %d\n", m.total)
+
+	fmt.Fprintln(w, "# HELP tf_tfe_tasks_in_flight The number of tasks that are being migrated.")
+	fmt.Fprintln(w, "# TYPE tf_tfe_tasks_in_flight gauge")
+	fmt.Fprintf(w, "tf_tfe_tasks_in_flight %d\n", m.inFlight)
+
+	fmt.Fprintln(w, "# HELP tf_tfe_tasks_completed_total The number of finished tasks by status.")
+	fmt.Fprintln(w, "# TYPE tf_tfe_tasks_completed_total counter")
+	for _, status := range []string{statusSucceeded, statusPartial, statusFailed, statusSkipped, statusCancelled} {
+		fmt.Fprintf(w, "tf_tfe_tasks_completed_total{status=%q} %d\n", status, m.completed[status])
+	}
+
+	fmt.Fprintln(w, "# HELP tf_tfe_phase_duration_seconds The duration of the phases of the tasks.")
+	fmt.Fprintln(w, "# TYPE tf_tfe_phase_duration_seconds histogram")
+	for _, phase := range phases {
+		h := m.phases[phase]
+		for i, le := range phaseBuckets {
+			fmt.Fprintf(w, "tf_tfe_phase_duration_seconds_bucket{phase=%q,le=%q} %d\n",
+				phase, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "tf_tfe_phase_duration_seconds_bucket{phase=%q,le=\"+Inf\"} %d\n", phase, h.count)
+		fmt.Fprintf(w, "tf_tfe_phase_duration_seconds_sum{phase=%q} %g\n", phase, h.sum)
+		fmt.Fprintf(w, "tf_tfe_phase_duration_seconds_count{phase=%q} %d\n", phase, h.count)
+	}
+}
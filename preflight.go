@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Pinger is implemented by config stores that can check their credentials
+// without needing a task.
+type Pinger interface {
+	// Ping checks that the store can be reached using its credentials.
+	Ping(ctx context.Context) error
+}
+
+// preflight checks the TFE token and organizations, the AWS credentials, the
+// credentials of the used VCS providers and the availability of terraform (when
+// formatting config files) before any task is started. This way invalid
+// credentials result in a single error, instead of failing every task.
+func (m *Migrator) preflight(ctx context.Context, tasks []*Task) error {
+	if !m.backendUpdateOnly {
+		checked := make(map[string]bool)
+		for _, t := range tasks {
+			if checked[t.organization] {
+				continue
+			}
+			if _, err := m.organizations.Read(ctx, t.organization); err != nil {
+				return fmt.Errorf("Unable to read organization %q from TFE: %v", t.organization, err)
+			}
+			checked[t.organization] = true
+		}
+
+		// S3-compatible stores don't support STS, so their credentials
+		// can't be checked up front.
+		for _, t := range tasks {
+			if t.source != s3Source || m.s3Clients.customEndpoint() {
+				continue
+			}
+			// Any roles are assumed using the default credentials, so
+			// those are the ones to check.
+			_, err := sts.New(m.s3Clients.sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				return fmt.Errorf("Unable to verify the AWS credentials: %v", err)
+			}
+			break
+		}
+	}
+
+	if !m.noBackendUpdate {
+		// The config files are formatted by terraform itself, so make
+		// sure it's available and log which one is used.
+		if m.formatFile {
+			path, err := exec.LookPath("terraform")
+			if err != nil {
+				return fmt.Errorf("The -format-file flag requires terraform to be in the PATH: %v", err)
+			}
+			slog.Info("Formatting config files using terraform fmt", "terraform", path)
+		}
+
+		for provider, store := range m.stores {
+			p, ok := store.(Pinger)
+			if !ok {
+				continue
+			}
+			if err := p.Ping(ctx); err != nil {
+				return fmt.Errorf("Unable to connect to %s: %v", provider, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkConfigFiles reads and parses the config file of every task, so missing
+// config files (e.g. because of a typo in the path) are reported for all tasks
+// together before any of them is started. Tasks sharing a config file only
+// read it once, and at most workers files are read concurrently.
+func (m *Migrator) checkConfigFiles(ctx context.Context, tasks []*Task, workers int) error {
+	// Select the config files to check before starting any of the checks,
+	// so the results aren't read while the checks are writing them.
+	var unique []*Task
+	results := make(map[string]error)
+	for _, t := range tasks {
+		key := configFileKey(t)
+		if _, ok := results[key]; !ok {
+			results[key] = nil
+			unique = append(unique, t)
+		}
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+
+	for _, t := range unique {
+		key := configFileKey(t)
+
+		wg.Add(1)
+		go func(t *Task) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			// Check a copy, as the config file of the task is replaced
+			// when a candidate file has the terraform block.
+			c := *t
+			err := m.checkConfigFile(ctx, &c)
+
+			mu.Lock()
+			results[key] = err
+			mu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+
+	var errs []string
+	for _, t := range tasks {
+		if err := results[configFileKey(t)]; err != nil {
+			errs = append(errs, fmt.Sprintf("record %d: %v", t.record, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Found %d invalid config files:\n  %s", len(errs), strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// checkConfigFile checks that the config file of the task can be read and
+// contains a terraform block.
+func (m *Migrator) checkConfigFile(ctx context.Context, t *Task) error {
+	if t.configFile == "" {
+		return fmt.Errorf("Missing config file (use -default-config-file or the configFile column)")
+	}
+
+	store, ok := m.stores[t.vcs]
+	if !ok {
+		return fmt.Errorf("Unsupported VCS provider %q", t.vcs)
+	}
+
+	content, err := m.readConfigFile(ctx, t, store)
+	if err != nil {
+		return fmt.Errorf("Unable to read config file %q from %s: %v", t.configFile, t.vcs, err)
+	}
+
+	// Without a terraform block, one may be added to the config file.
+	_, _, _, err = replaceBackend(content, m.backendConfig(t))
+	if err == errNoTerraformBlock && m.appendsBackend() {
+		return nil
+	}
+	if err == errNoTerraformBlock {
+		return fmt.Errorf("No terraform configuration block found in %q (use -create-backend to add one)", t.configFile)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to parse config file %q: %v", t.configFile, err)
+	}
+
+	return nil
+}
+
+// configFileKey returns the key identifying the config file of the task.
+func configFileKey(t *Task) string {
+	return strings.Join([]string{t.vcs, t.project, t.repo, t.branch, t.configFile}, "\x00")
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreflightTerraform(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	m := &Migrator{backendUpdateOnly: true, formatFile: true}
+
+	err := m.preflight(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "requires terraform to be in the PATH") {
+		t.Fatalf("expected terraform to be missing, got: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "terraform"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.preflight(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Terraform is not needed when the config files are not formatted.
+	m.formatFile = false
+	t.Setenv("PATH", t.TempDir())
+	if err := m.preflight(context.Background(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckConfigFiles(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d"} {
+		path := filepath.Join(root, "repo", name, "main.tf")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("terraform {\n  backend \"s3\" {}\n}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &Migrator{
+		stores:    map[string]ConfigStore{localVCS: &local{root: root}},
+		repoLocks: make(map[string]chan struct{}),
+	}
+
+	// Several tasks share a config file, and two config files don't exist.
+	var tasks []*Task
+	for i, configFile := range []string{"a/main.tf", "b/main.tf", "a/main.tf", "x/main.tf", "c/main.tf", "d/main.tf", "b/main.tf", "y/main.tf", "x/main.tf"} {
+		tasks = append(tasks, &Task{
+			record:       i + 1,
+			vcs:          localVCS,
+			repo:         "repo",
+			configFile:   configFile,
+			organization: "org",
+			workspace:    fmt.Sprintf("ws-%d", i+1),
+		})
+	}
+
+	err := m.checkConfigFiles(context.Background(), tasks, 3)
+	if err == nil {
+		t.Fatalf("expected the missing config files to be reported")
+	}
+	if !strings.HasPrefix(err.Error(), "Found 3 invalid config files:") {
+		t.Errorf("expected the 3 tasks with a missing config file to be reported, got: %v", err)
+	}
+	for _, record := range []string{"record 4:", "record 8:", "record 9:"} {
+		if !strings.Contains(err.Error(), record) {
+			t.Errorf("expected %s to be reported, got: %v", record, err)
+		}
+	}
+}
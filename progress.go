@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is the interval at which the progress line is updated.
+const progressInterval = 200 * time.Millisecond
+
+// progress renders a live progress line, which is updated in place. It's
+// only used when stdout is a terminal.
+type progress struct {
+	mu        sync.Mutex
+	w         io.Writer
+	action    string
+	total     int
+	inFlight  int
+	completed int
+	failed    int
+	start     time.Time
+	stopped   bool
+	done      chan struct{}
+}
+
+// newProgress returns a progress line for the given number of tasks, which
+// is rendered until stop is called.
+func newProgress(w io.Writer, action string, total int) *progress {
+	p := &progress{
+		w:      w,
+		action: action,
+		total:  total,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// taskStarted records that a task is started.
+func (p *progress) taskStarted() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight++
+}
+
+// taskDone records that a task is finished with the given status.
+func (p *progress) taskDone(status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	p.completed++
+	if status == statusFailed {
+		p.failed++
+	}
+}
+
+// run updates the progress line until the progress is stopped, so the
+// elapsed time keeps ticking even when no tasks are finished.
+func (p *progress) run() {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			p.render()
+			p.mu.Unlock()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// render writes the progress line. It must be called with p.mu held.
+func (p *progress) render() {
+	if p.stopped {
+		return
+	}
+	fmt.Fprintf(
+		p.w, "\r\033[K%s %d/%d workspaces (%d failed), %d in flight, %s elapsed",
+		p.action, p.completed, p.total, p.failed, p.inFlight,
+		time.Since(p.start).Round(time.Second),
+	)
+}
+
+// clear removes the progress line. It must be called with p.mu held.
+func (p *progress) clear() {
+	fmt.Fprint(p.w, "\r\033[K")
+}
+
+// stop stops updating and removes the progress line.
+func (p *progress) stop() {
+	close(p.done)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	p.stopped = true
+}
+
+// writer returns a writer that writes to w without garbling the progress
+// line, by removing it before writing and rendering it again afterwards.
+func (p *progress) writer(w io.Writer) io.Writer {
+	return &progressWriter{p: p, w: w}
+}
+
+// progressWriter is an io.Writer that writes around the progress line.
+type progressWriter struct {
+	p *progress
+	w io.Writer
+}
+
+// Write implements io.Writer.
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	pw.p.mu.Lock()
+	defer pw.p.mu.Unlock()
+
+	pw.p.clear()
+	n, err := pw.w.Write(b)
+	pw.p.render()
+
+	return n, err
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter that allows rps requests (or
+// bytes) per second. It's shared by all workers, so it limits the total rate
+// independent of the number of workers.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rps    float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rate limiter allowing rps requests per second.
+func newRateLimiter(rps float64) *rateLimiter {
+	return &rateLimiter{
+		rps:    rps,
+		tokens: 1,
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request is allowed or the context is done.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are allowed or the context is done.
+func (l *rateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+
+	// Refill the bucket with the tokens added since the last request. The
+	// bucket holds a single token, so requests are spread out evenly.
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rps
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+
+	// Take the tokens, and wait until they are available if they aren't yet.
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.rps * float64(time.Second))
+
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Return the tokens we didn't use.
+		l.mu.Lock()
+		l.tokens += float64(n)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// rateLimitedTransport is an http.RoundTripper that waits for the rate
+// limiter before sending each request.
+type rateLimitedTransport struct {
+	limiter *rateLimiter
+	next    http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.next.RoundTrip(req)
+}
+
+// rateLimitedWriterAt is an io.WriterAt that waits for the rate limiter
+// before writing, to limit the bandwidth used to write to it.
+type rateLimitedWriterAt struct {
+	ctx     context.Context
+	limiter *rateLimiter
+	w       io.WriterAt
+}
+
+// WriteAt implements io.WriterAt.
+func (w *rateLimitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if err := w.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.w.WriteAt(p, off)
+}
+
+// rateLimitedReader is an io.Reader that waits for the rate limiter after
+// reading, to limit the bandwidth used to read from it.
+type rateLimitedReader struct {
+	ctx     context.Context
+	limiter *rateLimiter
+	r       io.Reader
+}
+
+// Read implements io.Reader.
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Possible statuses of a migration task.
+const (
+	statusSucceeded = "succeeded"
+	statusPartial   = "partial"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+	statusCancelled = "cancelled"
+)
+
+// Result represents the outcome of a single migration task. A task that
+// migrated the state but failed to update the backend configuration has
+// the partial status and the error of the update in BackendError.
+type Result struct {
+	Status       string
+	Error        error
+	BackendError error
+	Duration     time.Duration
+
+	task *Task
+}
+
+// collectResults drains the results channel and returns a result for every
+// task, in the same order as the tasks. Tasks that never reported a result
+// are marked as skipped.
+func collectResults(tasks []*Task, results <-chan *Result) []*Result {
+	byTask := make(map[*Task]*Result, len(tasks))
+	for result := range results {
+		byTask[result.task] = result
+	}
+
+	all := make([]*Result, 0, len(tasks))
+	for _, task := range tasks {
+		result, ok := byTask[task]
+		if !ok {
+			result = &Result{Status: statusSkipped, task: task}
+		}
+		all = append(all, result)
+	}
+
+	return all
+}
+
+// reportEntry is a single entry of the migration report.
+type reportEntry struct {
+	Workspace    string `json:"workspace"`
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+	BackendError string `json:"backend_error,omitempty"`
+	Duration     string `json:"duration"`
+	Lineage      string `json:"lineage,omitempty"`
+	Serial       int64  `json:"serial"`
+	CommitBranch string `json:"commit_branch,omitempty"`
+	PullRequest  string `json:"pull_request,omitempty"`
+	Run          string `json:"run,omitempty"`
+	Audit        string `json:"audit,omitempty"`
+
+	// The duration of each phase that was started.
+	Timings map[string]string `json:"timings,omitempty"`
+}
+
+func newReportEntry(r *Result) *reportEntry {
+	entry := &reportEntry{
+		Workspace:    r.task.workspace,
+		Bucket:       r.task.bucket,
+		Key:          r.task.key,
+		Status:       r.Status,
+		Duration:     r.Duration.String(),
+		Lineage:      r.task.meta.Lineage,
+		Serial:       r.task.meta.Serial,
+		CommitBranch: r.task.commitBranch,
+		PullRequest:  r.task.pullRequestURL,
+		Run:          r.task.runURL,
+		Audit:        r.task.audit,
+	}
+	if r.Error != nil {
+		entry.Error = r.Error.Error()
+	}
+	if r.BackendError != nil {
+		entry.BackendError = r.BackendError.Error()
+	}
+	for phase, d := range r.task.timings {
+		if entry.Timings == nil {
+			entry.Timings = make(map[string]string)
+		}
+		entry.Timings[phase] = d.String()
+	}
+	return entry
+}
+
+// resultStream writes the result of every task as a JSON object on a single
+// line (JSON Lines), as soon as the task is finished. It's safe to use
+// concurrently.
+type resultStream struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newResultStream returns a stream writing the results to w.
+func newResultStream(w io.Writer) *resultStream {
+	return &resultStream{enc: json.NewEncoder(w)}
+}
+
+// write writes the result as a single line.
+func (s *resultStream) write(r *Result) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(newReportEntry(r))
+}
+
+// writeReport writes the results to the given path. The report is written as
+// CSV if the path has a .csv extension and as JSON otherwise.
+func writeReport(path string, results []*Result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries := make([]*reportEntry, 0, len(results))
+	for _, r := range results {
+		entries = append(entries, newReportEntry(r))
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		w := csv.NewWriter(f)
+		header := []string{"workspace", "bucket", "key", "status", "error", "backend_error", "duration", "lineage", "serial", "commit_branch", "pull_request", "run", "audit"}
+		for _, phase := range phases {
+			header = append(header, phase+"_duration")
+		}
+		w.Write(header)
+
+		for _, e := range entries {
+			record := []string{
+				e.Workspace, e.Bucket, e.Key, e.Status, e.Error, e.BackendError, e.Duration,
+				e.Lineage, strconv.FormatInt(e.Serial, 10), e.CommitBranch, e.PullRequest, e.Run, e.Audit,
+			}
+			for _, phase := range phases {
+				record = append(record, e.Timings[phase])
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(entries); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// printTimings prints the median (p50) and 95th percentile (p95) duration of
+// each phase across all tasks that started the phase.
+func printTimings(w io.Writer, results []*Result) {
+	var stats []string
+	for _, phase := range phases {
+		var durations []time.Duration
+		for _, r := range results {
+			if d, ok := r.task.timings[phase]; ok {
+				durations = append(durations, d)
+			}
+		}
+		if len(durations) == 0 {
+			continue
+		}
+
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		stats = append(stats, fmt.Sprintf(
+			"%s %v/%v", phase,
+			percentile(durations, 50).Round(time.Millisecond),
+			percentile(durations, 95).Round(time.Millisecond),
+		))
+	}
+
+	if len(stats) > 0 {
+		fmt.Fprintf(w, "Phase durations (p50/p95): %s\n", strings.Join(stats, ", "))
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations, using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+const (
+	// The default number of times a failed TFE API call is retried.
+	defaultMaxRetries = 3
+
+	// The minimum and maximum time to wait between retries.
+	minBackoff = 1 * time.Second
+	maxBackoff = 30 * time.Second
+)
+
+// go-tfe doesn't expose the status code or headers of a failed request, so
+// we have to match on the error message to decide if an error is retryable.
+// If the response has no error payload the message is the HTTP status, else
+// the message contains the error titles from the payload.
+var (
+	retryableStatuses = []string{"429 ", "500 ", "502 ", "503 ", "504 "}
+	retryableErrors   = []string{
+		"too many requests",
+		"internal server error",
+		"bad gateway",
+		"service unavailable",
+		"gateway timeout",
+	}
+)
+
+// retry calls fn until it succeeds, returns an error that is not retryable or
+// until the maximum number of retries is reached. Between attempts it backs
+// off exponentially, with some jitter to spread out the concurrent workers.
+// When the error tells how long to wait (see retryAfterError), it waits at
+// least that long.
+func (m *Migrator) retry(ctx context.Context, t *Task, action string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt > m.maxRetries {
+			return err
+		}
+
+		wait := backoff(attempt)
+
+		var retryAfter *retryAfterError
+		if errors.As(err, &retryAfter) && retryAfter.wait > wait {
+			wait = retryAfter.wait
+		}
+
+		t.logger().Debug(
+			"Retrying "+action,
+			"wait", wait, "retry", attempt, "max_retries", m.maxRetries, "error", err,
+		)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isRetryable reports whether the error is caused by a condition that is
+// likely to be temporary, like rate limiting, server or connection errors.
+func isRetryable(err error) bool {
+	if err == tfe.ErrUnauthorized || err == tfe.ErrResourceNotFound {
+		return false
+	}
+
+	var retryAfter *retryAfterError
+	if errors.As(err, &retryAfter) {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, status := range retryableStatuses {
+		if strings.HasPrefix(msg, status) {
+			return true
+		}
+	}
+	for _, retryable := range retryableErrors {
+		if strings.Contains(msg, retryable) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff returns the time to wait before the given retry attempt.
+func backoff(attempt int) time.Duration {
+	wait := minBackoff << uint(attempt-1)
+	if wait <= 0 || wait > maxBackoff {
+		wait = maxBackoff
+	}
+
+	// Wait at least half of the calculated time plus a random jitter.
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runPollInterval is the interval at which the status of a triggered run is
+// checked, when waiting for it to finish.
+const runPollInterval = 5 * time.Second
+
+// The statuses of a run in which its plan is successfully finished, and in
+// which the run failed.
+var (
+	runPlannedStatuses = map[string]bool{
+		"planned":              true,
+		"planned_and_finished": true,
+		"policy_checked":       true,
+		"cost_estimated":       true,
+		"applied":              true,
+	}
+	runFailedStatuses = map[string]bool{
+		"errored":            true,
+		"canceled":           true,
+		"force_canceled":     true,
+		"discarded":          true,
+		"policy_soft_failed": true,
+	}
+)
+
+// runResponse is the part of a run API response used by the migrator.
+type runResponse struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			Status string `json:"status"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// queuePlan queues a plan-only run in the workspace of the task, to check
+// that the migrated workspace works, and records the URL of the run. The
+// vendored version of go-tfe doesn't support plan-only runs (and can't decode
+// the nested attributes of a run), so runs are managed using raw API requests.
+func (m *Migrator) queuePlan(ctx context.Context, t *Task, workspaceID string) error {
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "runs",
+			"attributes": map[string]interface{}{
+				"message":   "Queued by the migration to Terraform Enterprise",
+				"plan-only": true,
+			},
+			"relationships": map[string]interface{}{
+				"workspace": relationship("workspaces", workspaceID),
+			},
+		},
+	}
+
+	var run runResponse
+	if err := m.apiRequest(ctx, "POST", "runs", body, &run); err != nil {
+		return fmt.Errorf("Failed to trigger a plan: %v", err)
+	}
+
+	t.runURL = fmt.Sprintf(
+		"%s/app/%s/workspaces/%s/runs/%s",
+		strings.TrimSuffix(m.config.Address, "/"), t.organization, t.workspace, run.Data.ID,
+	)
+	t.logger().Info("Triggered plan", "run", t.runURL)
+
+	if !m.waitPlan {
+		return nil
+	}
+
+	return m.waitForPlan(ctx, run.Data.ID)
+}
+
+// waitForPlan polls the status of the run until its plan is finished. An
+// error is returned if the run failed.
+func (m *Migrator) waitForPlan(ctx context.Context, runID string) error {
+	for {
+		var run runResponse
+		if err := m.apiRequest(ctx, "GET", "runs/"+runID, nil, &run); err != nil {
+			return fmt.Errorf("Failed to read run %s: %v", runID, err)
+		}
+
+		status := run.Data.Attributes.Status
+		if runPlannedStatuses[status] {
+			return nil
+		}
+		if runFailedStatuses[status] {
+			return fmt.Errorf("Plan of run %s failed with status %s", runID, status)
+		}
+
+		select {
+		case <-time.After(runPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
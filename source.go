@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// Supported state sources. The source of a task is selected by prefixing
+// the bucket with the source followed by "://", e.g. gs://my-bucket.
+const (
+	s3Source  = "s3"
+	gcsSource = "gs"
+	tfeSource = "tfe"
+)
+
+const gcsObjectURL = "https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media"
+
+// StateDownloader downloads states from a storage backend.
+type StateDownloader interface {
+	// Download returns the state of the task.
+	Download(ctx context.Context, t *Task) ([]byte, error)
+}
+
+// FileDownloader is implemented by state downloaders that can download a
+// state directly to a file, instead of holding it in memory.
+type FileDownloader interface {
+	// DownloadFile writes the state of the task to f.
+	DownloadFile(ctx context.Context, t *Task, f *os.File) error
+}
+
+// parseSource splits the optional source prefix from the bucket. Buckets
+// without a prefix are S3 buckets.
+func parseSource(bucket string) (source, name string) {
+	if i := strings.Index(bucket, "://"); i != -1 {
+		return bucket[:i], bucket[i+3:]
+	}
+	return s3Source, bucket
+}
+
+// s3Clients creates and caches an S3 client for every IAM role used, so
+// each role is only assumed once.
+type s3Clients struct {
+	sess       *session.Session
+	config     *aws.Config
+	roleARN    string
+	externalID string
+
+	mu      sync.Mutex
+	clients map[string]s3iface.S3API
+	creds   map[string]*credentials.Credentials
+}
+
+// newS3Clients returns S3 clients using the given session, with the config
+// (e.g. a custom endpoint) applied to the S3 clients only. If roleARN is not
+// empty, it is assumed by clients for tasks that don't specify a role.
+func newS3Clients(sess *session.Session, config *aws.Config, roleARN, externalID string) *s3Clients {
+	return &s3Clients{
+		sess:       sess,
+		config:     config,
+		roleARN:    roleARN,
+		externalID: externalID,
+		clients:    make(map[string]s3iface.S3API),
+		creds:      make(map[string]*credentials.Credentials),
+	}
+}
+
+// client returns the S3 client that assumes the given role. The client is
+// returned as an s3iface.S3API, so clients can be replaced with fakes by
+// adding them to the cache.
+func (c *s3Clients) client(roleARN string) s3iface.S3API {
+	if roleARN == "" {
+		roleARN = c.roleARN
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client, ok := c.clients[roleARN]; ok {
+		return client
+	}
+
+	client := s3.New(c.sess, c.config.Copy().WithCredentials(c.roleCredentials(roleARN)))
+	c.clients[roleARN] = client
+
+	return client
+}
+
+// customEndpoint reports whether the S3 clients use a custom endpoint, like
+// the endpoint of an S3-compatible store.
+func (c *s3Clients) customEndpoint() bool {
+	return aws.StringValue(c.config.Endpoint) != ""
+}
+
+// credentials returns the credentials used by clients that assume the given
+// role, so other AWS services can be accessed using the same role.
+func (c *s3Clients) credentials(roleARN string) *credentials.Credentials {
+	if roleARN == "" {
+		roleARN = c.roleARN
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.roleCredentials(roleARN)
+}
+
+// roleCredentials returns the (cached) credentials for the role. It must be
+// called with c.mu held.
+func (c *s3Clients) roleCredentials(roleARN string) *credentials.Credentials {
+	if roleARN == "" {
+		return c.sess.Config.Credentials
+	}
+
+	if creds, ok := c.creds[roleARN]; ok {
+		return creds
+	}
+
+	// The credentials are refreshed automatically before they expire.
+	creds := stscreds.NewCredentials(c.sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		if c.externalID != "" {
+			p.ExternalID = aws.String(c.externalID)
+		}
+	})
+	c.creds[roleARN] = creds
+
+	return creds
+}
+
+// s3Downloader downloads states from AWS S3. If kmsKeyID is not empty, the
+// states are expected to be encrypted using that KMS key. If limiter is not
+// nil, the bandwidth of all downloads is limited to its rate in bytes.
+type s3Downloader struct {
+	clients  *s3Clients
+	kmsKeyID string
+	limiter  *rateLimiter
+}
+
+// Download implements StateDownloader.
+func (d *s3Downloader) Download(ctx context.Context, t *Task) ([]byte, error) {
+	buf := aws.NewWriteAtBuffer(nil)
+	if err := d.download(ctx, t, buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadFile implements FileDownloader.
+func (d *s3Downloader) DownloadFile(ctx context.Context, t *Task, f *os.File) error {
+	return d.download(ctx, t, f)
+}
+
+// download writes the state of the task to w.
+func (d *s3Downloader) download(ctx context.Context, t *Task, w io.WriterAt) error {
+	client := d.clients.client(t.roleARN)
+
+	// Verify the encryption of the object before downloading it.
+	kmsKeyID := t.kmsKeyID
+	if kmsKeyID == "" {
+		kmsKeyID = d.kmsKeyID
+	}
+	if kmsKeyID != "" {
+		head, err := client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(t.key),
+		})
+		if err != nil {
+			return s3Error(t, err)
+		}
+
+		if aws.StringValue(head.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms {
+			return fmt.Errorf("state s3://%s/%s is not encrypted using SSE-KMS", t.bucket, t.key)
+		}
+		if !matchKMSKey(aws.StringValue(head.SSEKMSKeyId), kmsKeyID) {
+			return fmt.Errorf(
+				"state s3://%s/%s is encrypted using KMS key %s, expected %s",
+				t.bucket, t.key, aws.StringValue(head.SSEKMSKeyId), kmsKeyID,
+			)
+		}
+	}
+
+	downloader := s3manager.NewDownloaderWithClient(client)
+
+	// Write through the limiter, so the downloader is slowed down to the
+	// configured bandwidth.
+	if d.limiter != nil {
+		w = &rateLimitedWriterAt{ctx: ctx, limiter: d.limiter, w: w}
+	}
+
+	_, err := downloader.DownloadWithContext(ctx, w,
+		&s3.GetObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(t.key),
+		},
+	)
+	if err != nil {
+		return s3Error(t, err)
+	}
+
+	return nil
+}
+
+// s3Error returns a descriptive error for S3 errors that are caused by a
+// missing object or by missing permissions, instead of the opaque SDK error.
+func s3Error(t *Task, err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	switch code := aerr.Code(); {
+	case code == s3.ErrCodeNoSuchBucket:
+		return fmt.Errorf("bucket %s not found: %s", t.bucket, aerr.Message())
+	case code == s3.ErrCodeNoSuchKey || code == "NotFound":
+		return fmt.Errorf("state s3://%s/%s not found", t.bucket, t.key)
+	case code == "AccessDenied" || code == "Forbidden":
+		return fmt.Errorf(
+			"access denied to state s3://%s/%s (check the s3:GetObject permission "+
+				"and, when encrypted using SSE-KMS, the kms:Decrypt permission): %s",
+			t.bucket, t.key, aerr.Message(),
+		)
+	case strings.HasPrefix(code, "KMS."):
+		return fmt.Errorf("unable to decrypt state s3://%s/%s: %s: %s", t.bucket, t.key, code, aerr.Message())
+	default:
+		return err
+	}
+}
+
+// matchKMSKey reports whether the KMS key ARN matches the expected key, which
+// can either be the ARN or the ID of the key.
+func matchKMSKey(arn, expected string) bool {
+	return arn == expected || strings.HasSuffix(arn, ":key/"+expected)
+}
+
+// gcsDownloader downloads states from Google Cloud Storage using the JSON API.
+type gcsDownloader struct {
+	token  string
+	client *http.Client
+}
+
+// Download implements StateDownloader.
+func (d *gcsDownloader) Download(ctx context.Context, t *Task) ([]byte, error) {
+	// Compose the URL for the given object.
+	u := fmt.Sprintf(gcsObjectURL, url.PathEscape(t.bucket), url.PathEscape(t.key))
+
+	// Create the request.
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	// Without a token only public objects can be downloaded.
+	if d.token != "" {
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	}
+
+	// Make the API call to download the object.
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		var response struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+
+		// Try to parse the error in order to get a descriptive error.
+		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil || response.Error.Message == "" {
+			return nil, fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+
+		return nil, fmt.Errorf("%s: %s", resp.Status, response.Error.Message)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// tfeDownloader downloads the current states of workspaces in a (source) TFE
+// organization. For these tasks the bucket is the organization and the key is
+// the name of the workspace, e.g. tfe://my-old-org,my-workspace.
+type tfeDownloader struct {
+	workspaces    WorkspaceService
+	stateVersions StateVersionService
+}
+
+// Download implements StateDownloader.
+func (d *tfeDownloader) Download(ctx context.Context, t *Task) ([]byte, error) {
+	w, err := d.workspaces.Read(ctx, t.bucket, t.key)
+	if err == tfe.ErrResourceNotFound {
+		return nil, fmt.Errorf("source workspace %s/%s not found", t.bucket, t.key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sv, err := d.stateVersions.Current(ctx, w.ID)
+	if err == tfe.ErrResourceNotFound {
+		return nil, fmt.Errorf("source workspace %s/%s has no state", t.bucket, t.key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return d.stateVersions.Download(ctx, sv.DownloadURL)
+}
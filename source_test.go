@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 is an S3 client serving the objects of a single bucket by key. Only
+// the calls used to download states are implemented.
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	object, ok := f.objects[aws.StringValue(in.Key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+
+	// The downloader requests the object in ranges of bytes.
+	var start, end int64
+	if _, err := fmt.Sscanf(aws.StringValue(in.Range), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	size := int64(len(object))
+	if end >= size {
+		end = size - 1
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          ioutil.NopCloser(bytes.NewReader(object[start : end+1])),
+		ContentLength: aws.Int64(end - start + 1),
+		ContentRange:  aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, size)),
+	}, nil
+}
+
+// newFakeS3 returns a migrator downloading the states from the fake S3.
+func newFakeS3(objects map[string][]byte) *Migrator {
+	clients := &s3Clients{
+		clients: map[string]s3iface.S3API{"": &fakeS3{objects: objects}},
+	}
+
+	return &Migrator{
+		downloaders: map[string]StateDownloader{
+			s3Source: &s3Downloader{clients: clients},
+		},
+	}
+}
+
+func TestDownloadStateS3(t *testing.T) {
+	stored := []byte(`{"version": 3, "terraform_version": "0.11.14", "serial": 4, "lineage": "abc", "modules": []}`)
+	m := newFakeS3(map[string][]byte{"app.tfstate": stored})
+
+	// Download the state both in memory and to a temporary file.
+	for _, tempDir := range []string{"", t.TempDir()} {
+		m.tempDir = tempDir
+		task := &Task{source: s3Source, bucket: "states", key: "app.tfstate", meta: &Meta{}}
+
+		if err := m.downloadState(context.Background(), task); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer task.removeStateFile()
+
+		state := task.stateReader()
+		if _, err := state.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, stored) {
+			t.Errorf("expected the state as stored, got %q", got)
+		}
+		if task.meta.Lineage != "abc" || task.meta.Serial != 4 || task.meta.TerraformVersion != "0.11.14" {
+			t.Errorf("unexpected metadata: %+v", task.meta)
+		}
+	}
+}
+
+func TestDownloadStateS3Errors(t *testing.T) {
+	m := newFakeS3(map[string][]byte{
+		"malformed.tfstate":  []byte(`{"version": 3, "serial": 4, "lineage": `),
+		"incomplete.tfstate": []byte(`{"version": 3, "serial": 4, "modules": []}`),
+	})
+
+	cases := []struct {
+		key string
+		err string
+	}{
+		{"malformed.tfstate", `Failed to parse the state file "malformed.tfstate"`},
+		{"incomplete.tfstate", "Unable to retrieve required fields from the state file"},
+		{"missing.tfstate", "state s3://states/missing.tfstate not found"},
+	}
+
+	for _, c := range cases {
+		task := &Task{source: s3Source, bucket: "states", key: c.key, meta: &Meta{}}
+
+		err := m.downloadState(context.Background(), task)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected an error containing %q, got: %v", c.key, c.err, err)
+		}
+	}
+}
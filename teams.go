@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// Supported permission levels of team access to a workspace.
+var teamAccessLevels = []string{"read", "plan", "write", "admin"}
+
+// teamAccess is the access of a team to a workspace.
+type teamAccess struct {
+	team   string
+	teamID string
+	access string
+}
+
+// parseTeamAccess parses a comma or semicolon separated list of team=access
+// pairs, like "devs=write;ops=admin".
+func parseTeamAccess(s string) (map[string]string, error) {
+	teams := make(map[string]string)
+
+	for _, pair := range parseList(s) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid team access %q, expected team=permission", pair)
+		}
+		teams[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return teams, validateTeamAccess(teams)
+}
+
+// validateTeamAccess checks that all permission levels are supported.
+func validateTeamAccess(teams map[string]string) error {
+	for team, access := range teams {
+		if !validTeamAccess(access) {
+			return fmt.Errorf(
+				"Invalid permission %q for team %q, must be one of %v", access, team, teamAccessLevels,
+			)
+		}
+	}
+	return nil
+}
+
+// validTeamAccess reports whether access is a supported permission level.
+func validTeamAccess(access string) bool {
+	for _, level := range teamAccessLevels {
+		if access == level {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceTeams returns the access of the teams to the workspace of the
+// task, where the access of the task takes precedence over the default access.
+func (m *Migrator) workspaceTeams(ctx context.Context, t *Task) ([]teamAccess, error) {
+	teams := make(map[string]string)
+	for team, access := range m.teamAccess {
+		teams[team] = access
+	}
+	for team, access := range t.teamAccess {
+		teams[team] = access
+	}
+
+	names := make([]string, 0, len(teams))
+	for team := range teams {
+		names = append(names, team)
+	}
+	sort.Strings(names)
+
+	var access []teamAccess
+	for _, name := range names {
+		teamID, err := m.teamID(ctx, t.organization, name)
+		if err != nil {
+			return nil, err
+		}
+		access = append(access, teamAccess{team: name, teamID: teamID, access: teams[name]})
+	}
+
+	return access, nil
+}
+
+// teamID returns the ID of the named team in the organization. All teams of
+// an organization are read once and cached.
+func (m *Migrator) teamID(ctx context.Context, organization, name string) (string, error) {
+	// Hold the lock during the lookup so concurrent workers don't all
+	// read the teams of the same organization.
+	m.teamsMu.Lock()
+	defer m.teamsMu.Unlock()
+
+	teams, ok := m.teams[organization]
+	if !ok {
+		var err error
+		if teams, err = m.listTeams(ctx, organization); err != nil {
+			return "", fmt.Errorf("Failed to read the teams of organization %q: %v", organization, err)
+		}
+		m.teams[organization] = teams
+	}
+
+	id, ok := teams[name]
+	if !ok {
+		return "", fmt.Errorf("Team %q does not exist in organization %q", name, organization)
+	}
+
+	return id, nil
+}
+
+// listTeams returns the IDs of all teams in the organization by name. The
+// teams are read using raw API requests, as the vendored jsonapi package
+// can't decode the nested permissions of a team.
+func (m *Migrator) listTeams(ctx context.Context, organization string) (map[string]string, error) {
+	teams := make(map[string]string)
+
+	for page := 1; page != 0; {
+		var response struct {
+			Data []struct {
+				ID         string `json:"id"`
+				Attributes struct {
+					Name string `json:"name"`
+				} `json:"attributes"`
+			} `json:"data"`
+			Meta struct {
+				Pagination struct {
+					NextPage int `json:"next-page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+
+		path := fmt.Sprintf(
+			"organizations/%s/teams?page[number]=%d&page[size]=100", url.QueryEscape(organization), page,
+		)
+		if err := m.apiRequest(ctx, "GET", path, nil, &response); err != nil {
+			return nil, err
+		}
+
+		for _, t := range response.Data {
+			teams[t.Attributes.Name] = t.ID
+		}
+
+		page = response.Meta.Pagination.NextPage
+	}
+
+	return teams, nil
+}
+
+// addTeamAccess gives the teams access to the workspace. Every team is added
+// (and retried) separately, as adding the same team twice fails. The vendored
+// jsonapi package can't decode the access of a team access response, so the
+// team access is added using raw API requests.
+func (m *Migrator) addTeamAccess(ctx context.Context, t *Task, w *tfe.Workspace, teams []teamAccess) error {
+	for _, ta := range teams {
+		body := map[string]interface{}{
+			"data": map[string]interface{}{
+				"type": "team-workspaces",
+				"attributes": map[string]interface{}{
+					"access": ta.access,
+				},
+				"relationships": map[string]interface{}{
+					"team":      relationship("teams", ta.teamID),
+					"workspace": relationship("workspaces", w.ID),
+				},
+			},
+		}
+
+		err := m.retry(ctx, t, "adding team access", func() error {
+			return m.apiRequest(ctx, "POST", "team-workspaces", body, nil)
+		})
+
+		// The team already has access when configuring a workspace
+		// created by an earlier run.
+		if err != nil && strings.Contains(strings.ToLower(err.Error()), "has already been taken") {
+			t.logger().Debug("Team already has access to the workspace", "team", ta.team)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to add %s access for team %q: %v", ta.access, ta.team, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// variable represents a single workspace variable in a variables file.
+type variable struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Category  string `json:"category"`
+	HCL       bool   `json:"hcl"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+// readVariables reads a JSON array of variables from the file at path. The
+// category defaults to terraform when not set.
+func readVariables(path string) ([]*variable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	d.DisallowUnknownFields()
+
+	var vars []*variable
+	if err := d.Decode(&vars); err != nil {
+		return nil, fmt.Errorf("Failed to parse variables file %q: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range vars {
+		if v.Key == "" {
+			return nil, fmt.Errorf("Variable without a key in %q", path)
+		}
+
+		switch tfe.CategoryType(v.Category) {
+		case "":
+			v.Category = string(tfe.CategoryTerraform)
+		case tfe.CategoryTerraform, tfe.CategoryEnv:
+		default:
+			return nil, fmt.Errorf(
+				"Invalid category %q of variable %q in %q, must be terraform or env", v.Category, v.Key, path,
+			)
+		}
+
+		if seen[v.Category+"/"+v.Key] {
+			return nil, fmt.Errorf("Duplicate %s variable %q in %q", v.Category, v.Key, path)
+		}
+		seen[v.Category+"/"+v.Key] = true
+	}
+
+	return vars, nil
+}
+
+// updateVariables creates the variables of the task in the workspace. Any
+// existing variables with the same key and category are updated instead.
+func (m *Migrator) updateVariables(ctx context.Context, t *Task, w *tfe.Workspace) error {
+	if len(t.variables) == 0 {
+		return nil
+	}
+
+	var existing []*tfe.Variable
+	err := m.retry(ctx, t, "listing variables", func() (err error) {
+		existing, err = m.listVariables(ctx, t)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to list variables: %v", err)
+	}
+
+	ids := make(map[string]string)
+	for _, v := range existing {
+		ids[string(v.Category)+"/"+v.Key] = v.ID
+	}
+
+	for _, v := range t.variables {
+		v := v
+
+		id, ok := ids[v.Category+"/"+v.Key]
+		if !ok {
+			err = m.retry(ctx, t, "creating variable", func() error {
+				_, err := m.variables.Create(ctx, tfe.VariableCreateOptions{
+					Key:       tfe.String(v.Key),
+					Value:     tfe.String(v.Value),
+					Category:  tfe.Category(tfe.CategoryType(v.Category)),
+					HCL:       tfe.Bool(v.HCL),
+					Sensitive: tfe.Bool(v.Sensitive),
+					Workspace: w,
+				})
+				return err
+			})
+		} else {
+			err = m.retry(ctx, t, "updating variable", func() error {
+				_, err := m.variables.Update(ctx, id, tfe.VariableUpdateOptions{
+					Key:       tfe.String(v.Key),
+					Value:     tfe.String(v.Value),
+					HCL:       tfe.Bool(v.HCL),
+					Sensitive: tfe.Bool(v.Sensitive),
+				})
+				return err
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to set %s variable %q: %v", v.Category, v.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// listVariables returns all existing variables of the workspace of the task.
+func (m *Migrator) listVariables(ctx context.Context, t *Task) ([]*tfe.Variable, error) {
+	const pageSize = 100
+
+	var vars []*tfe.Variable
+	for page := 1; ; page++ {
+		vs, err := m.variables.List(ctx, tfe.VariableListOptions{
+			ListOptions:  tfe.ListOptions{PageNumber: page, PageSize: pageSize},
+			Organization: tfe.String(t.organization),
+			Workspace:    tfe.String(t.workspace),
+		})
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, vs...)
+
+		if len(vs) < pageSize {
+			return vars, nil
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"text/template"
+)
+
+// defaultCommitMessage is the default commit message template.
+const defaultCommitMessage = "Backend configuration updated by migration tool"
+
+// commitMessageData contains the values available in a commit message template.
+type commitMessageData struct {
+	Organization string
+	Workspace    string
+	Project      string
+	Repo         string
+	Branch       string
+	ConfigFile   string
+	Bucket       string
+	Key          string
+}
+
+// Supported VCS providers.
+const (
+	bitbucketVCS = "bitbucket"
+	githubVCS    = "github"
+	gitlabVCS    = "gitlab"
+	localVCS     = "local"
+)
+
+// errCommitConflict is returned (wrapped) by config stores when committing a
+// config file fails, because its branch was updated after it was read.
+var errCommitConflict = errors.New("branch was updated after reading the config file")
+
+// ConfigStore reads and writes the Terraform configuration files that contain
+// the backend configuration.
+type ConfigStore interface {
+	// Read returns the content of the config file of the task.
+	Read(ctx context.Context, t *Task) (string, error)
+
+	// Write commits the updated content of the config file of the task
+	// using the given commit message.
+	Write(ctx context.Context, t *Task, content, message string) error
+
+	// LatestCommit returns the ID of the latest commit of the repository.
+	LatestCommit(ctx context.Context, t *Task) (string, error)
+}
+
+// Default pull request templates.
+const (
+	defaultPRBranch      = "tfe-migration/{{.Workspace}}"
+	defaultPRTitle       = "Migrate {{.Workspace}} to Terraform Enterprise"
+	defaultPRDescription = "Updates the backend configuration in {{.ConfigFile}} to use the {{.Workspace}} workspace in the {{.Organization}} organization."
+)
+
+// pullRequest contains the details of a pull request to open.
+type pullRequest struct {
+	branch      string
+	message     string
+	title       string
+	description string
+}
+
+// PullRequester is implemented by config stores that can open a pull request
+// instead of committing directly to the branch of the task.
+type PullRequester interface {
+	// OpenPullRequest creates a new branch off the latest commit of the
+	// branch of the task, commits the updated content of the config file
+	// to it and opens a pull request against the branch of the task. It
+	// returns the URL of the pull request.
+	OpenPullRequest(ctx context.Context, t *Task, content string, pr *pullRequest) (string, error)
+}
+
+// BranchCreator is implemented by config stores that can create a branch, so
+// the updated config file can be committed to a new branch instead of the
+// branch of the task.
+type BranchCreator interface {
+	// CreateBranch creates the named branch off the latest commit of the
+	// branch of the task.
+	CreateBranch(ctx context.Context, t *Task, name string) error
+}
+
+// validVCS reports whether vcs is a supported VCS provider.
+func validVCS(vcs string) bool {
+	switch vcs {
+	case bitbucketVCS, githubVCS, gitlabVCS, localVCS:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseCommitMessage parses a commit message template. The template is
+// executed once with dummy values, so invalid field references fail now
+// instead of when committing. The same data is available to the pull request
+// templates, so they are parsed the same way.
+func parseCommitMessage(text string) (*template.Template, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(new(bytes.Buffer), commitMessageData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// commitMessage returns the commit message for the task.
+func (m *Migrator) commitMessage(t *Task) (string, error) {
+	return m.executeTemplate(m.commitTemplate, t)
+}
+
+// executeTemplate executes a commit message or pull request template for the
+// task.
+func (m *Migrator) executeTemplate(tmpl *template.Template, t *Task) (string, error) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, commitMessageData{
+		Organization: t.organization,
+		Workspace:    t.workspace,
+		Project:      t.project,
+		Repo:         t.repo,
+		Branch:       t.branch,
+		ConfigFile:   t.configFile,
+		Bucket:       t.bucket,
+		Key:          t.key,
+	})
+	return buf.String(), err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// versionPattern matches a semantic version, like 1.5.7 or 1.6.0-beta1.
+var versionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// validVersion reports whether v is a semantic version.
+func validVersion(v string) bool {
+	return versionPattern.MatchString(v)
+}
+
+// parseVersionMap parses a comma separated list of from=to version pairs.
+func parseVersionMap(s string) (map[string]string, error) {
+	versions := make(map[string]string)
+
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("Invalid version mapping %q, expected from=to", pair)
+		}
+
+		versions[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return versions, nil
+}
+
+// parseVersions parses a comma separated list of versions.
+func parseVersions(s string) map[string]bool {
+	versions := make(map[string]bool)
+
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			versions[v] = true
+		}
+	}
+
+	return versions
+}
+
+// terraformVersion returns the Terraform version to use for the workspace of
+// the task. It applies any configured version mapping and verifies that the
+// version is supported by TFE.
+func (m *Migrator) terraformVersion(ctx context.Context, t *Task) (string, error) {
+	version := t.meta.TerraformVersion
+	if v, ok := m.versionMap[version]; ok {
+		version = v
+	}
+
+	// An explicitly configured version takes precedence.
+	if t.terraformVersion != "" {
+		version = t.terraformVersion
+	}
+
+	// Lookup the supported versions only once per run.
+	m.versionsOnce.Do(func() {
+		if m.versions != nil {
+			return
+		}
+
+		versions, err := m.listTerraformVersions(ctx)
+		switch err {
+		case nil:
+			m.versions = versions
+		case tfe.ErrUnauthorized, tfe.ErrResourceNotFound:
+			slog.Warn(
+				"Unable to list the supported Terraform versions (an admin token is " +
+					"required), use -terraform-versions to validate versions",
+			)
+		default:
+			m.versionsErr = fmt.Errorf("Failed to list the supported Terraform versions: %v", err)
+		}
+	})
+	if m.versionsErr != nil {
+		return "", m.versionsErr
+	}
+
+	// If we don't know the supported versions, we cannot validate.
+	if m.versions != nil && !m.versions[version] {
+		return "", fmt.Errorf("Terraform version %s is not supported by TFE", version)
+	}
+
+	return version, nil
+}
+
+// listTerraformVersions returns the enabled Terraform versions using the
+// admin API.
+func (m *Migrator) listTerraformVersions(ctx context.Context) (map[string]bool, error) {
+	versions := make(map[string]bool)
+
+	for page := 1; page != 0; {
+		var response struct {
+			Data []struct {
+				Attributes struct {
+					Version string `json:"version"`
+					Enabled bool   `json:"enabled"`
+				} `json:"attributes"`
+			} `json:"data"`
+			Meta struct {
+				Pagination struct {
+					NextPage int `json:"next-page"`
+				} `json:"pagination"`
+			} `json:"meta"`
+		}
+
+		path := fmt.Sprintf("admin/terraform-versions?page[number]=%d&page[size]=100", page)
+		if err := m.apiRequest(ctx, "GET", path, nil, &response); err != nil {
+			return nil, err
+		}
+
+		for _, v := range response.Data {
+			if v.Attributes.Enabled {
+				versions[v.Attributes.Version] = true
+			}
+		}
+
+		page = response.Meta.Pagination.NextPage
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+// Supported workspace execution modes.
+var executionModes = []string{"remote", "local", "agent"}
+
+// The classes of errors returned when creating a workspace, which can be
+// detected using errors.Is.
+var (
+	errWorkspaceExists      = errors.New("workspace already exists")
+	errPermissionDenied     = errors.New("permission denied")
+	errOrganizationNotFound = errors.New("organization not found")
+)
+
+// classifyCreateError classifies an error returned when creating a workspace.
+// Like the retryable errors, the errors are matched on their message, as
+// go-tfe only has sentinel errors for 401 and 404 responses. Errors that
+// can't be classified are returned as is.
+func classifyCreateError(organization string, err error) error {
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case err == tfe.ErrUnauthorized,
+		strings.HasPrefix(msg, "403 "), strings.Contains(msg, "forbidden"):
+		return fmt.Errorf("%w to create workspaces in organization %q: %v", errPermissionDenied, organization, err)
+	case err == tfe.ErrResourceNotFound:
+		// TFE also responds with a 404 when the organization exists,
+		// but the token has no access to it.
+		return fmt.Errorf("%w or not accessible: %q", errOrganizationNotFound, organization)
+	case strings.Contains(msg, "has already been taken"):
+		return fmt.Errorf("%w: %v", errWorkspaceExists, err)
+	}
+
+	return err
+}
+
+// workspaceSettings contains the workspace attributes and relationships that
+// cannot be set using the vendored version of go-tfe.
+type workspaceSettings struct {
+	attributes    map[string]interface{}
+	relationships map[string]interface{}
+	tags          []string
+	teams         []teamAccess
+}
+
+// workspaceSettings returns the additional settings for the workspace of the
+// given task. It is called before creating the workspace, so any invalid
+// settings fail the task before the workspace is created.
+func (m *Migrator) workspaceSettings(ctx context.Context, t *Task) (*workspaceSettings, error) {
+	s := &workspaceSettings{
+		attributes:    make(map[string]interface{}),
+		relationships: make(map[string]interface{}),
+	}
+
+	// Validate the execution mode before making any API calls.
+	executionMode := t.executionMode
+	if executionMode == "" {
+		executionMode = m.execMode
+	}
+	if executionMode != "" {
+		if !validExecutionMode(executionMode) {
+			return nil, fmt.Errorf("Invalid execution mode %q, must be one of %v", executionMode, executionModes)
+		}
+		s.attributes["execution-mode"] = executionMode
+	}
+	switch {
+	case executionMode == "agent" && t.agentPoolID == "":
+		return nil, fmt.Errorf("An agent pool ID is required when using the agent execution mode")
+	case executionMode != "agent" && t.agentPoolID != "":
+		return nil, fmt.Errorf("An agent pool ID can only be used with the agent execution mode")
+	case t.agentPoolID != "":
+		s.attributes["agent-pool-id"] = t.agentPoolID
+	}
+
+	// Combine the default tags with the tags of the task, and the tags used
+	// to select the workspace in the cloud block.
+	tags := append(append([]string{}, m.defaultTags...), t.tags...)
+	tags = append(tags, m.selectionTags(t)...)
+
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if !seen[tag] {
+			s.tags = append(s.tags, tag)
+			seen[tag] = true
+		}
+	}
+
+	// Set the boolean settings, where those of the task take precedence.
+	for attribute, v := range m.toggles {
+		s.attributes[attribute] = v
+	}
+	for attribute, v := range t.toggles {
+		s.attributes[attribute] = v
+	}
+
+	if len(t.triggerPrefixes) > 0 {
+		s.attributes["trigger-prefixes"] = t.triggerPrefixes
+	}
+
+	if t.tfeProject != "" {
+		projectID, err := m.projectID(ctx, t.organization, t.tfeProject)
+		if err != nil {
+			return nil, err
+		}
+		s.relationships["project"] = relationship("projects", projectID)
+	}
+
+	// Look up the teams now, so unknown teams fail the task before the
+	// workspace is created.
+	teams, err := m.workspaceTeams(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	s.teams = teams
+
+	return s, nil
+}
+
+// validExecutionMode reports whether mode is a supported execution mode.
+func validExecutionMode(mode string) bool {
+	for _, m := range executionModes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// apply updates the workspace with the settings, if there are any.
+func (s *workspaceSettings) apply(ctx context.Context, m *Migrator, w *tfe.Workspace) error {
+	if err := s.applyAttributes(ctx, m, w); err != nil {
+		return err
+	}
+	return s.applyTags(ctx, m, w)
+}
+
+// applyAttributes updates the attributes and relationships of the workspace.
+func (s *workspaceSettings) applyAttributes(ctx context.Context, m *Migrator, w *tfe.Workspace) error {
+	if len(s.attributes) == 0 && len(s.relationships) == 0 {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"type": "workspaces",
+	}
+	if len(s.attributes) > 0 {
+		data["attributes"] = s.attributes
+	}
+	if len(s.relationships) > 0 {
+		data["relationships"] = s.relationships
+	}
+
+	path := fmt.Sprintf("workspaces/%s", url.QueryEscape(w.ID))
+	return m.apiRequest(ctx, "PATCH", path, map[string]interface{}{"data": data}, nil)
+}
+
+// applyTags adds the tags to the workspace.
+func (s *workspaceSettings) applyTags(ctx context.Context, m *Migrator, w *tfe.Workspace) error {
+	if len(s.tags) == 0 {
+		return nil
+	}
+
+	var tags []interface{}
+	for _, tag := range s.tags {
+		tags = append(tags, map[string]interface{}{
+			"type": "tags",
+			"attributes": map[string]interface{}{
+				"name": tag,
+			},
+		})
+	}
+
+	path := fmt.Sprintf("workspaces/%s/relationships/tags", url.QueryEscape(w.ID))
+	return m.apiRequest(ctx, "POST", path, map[string]interface{}{"data": tags}, nil)
+}
+
+// relationship returns a JSON:API relationship to a single resource.
+func relationship(kind, id string) map[string]interface{} {
+	return map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": kind,
+			"id":   id,
+		},
+	}
+}
+
+// projectID returns the ID of the named project in the organization. If the
+// project does not exist it is created when allowed, otherwise an error is
+// returned.
+func (m *Migrator) projectID(ctx context.Context, organization, name string) (string, error) {
+	// Hold the lock during the lookup so concurrent workers don't
+	// try to create the same project.
+	m.projectsMu.Lock()
+	defer m.projectsMu.Unlock()
+
+	// Projects are cached by organization, as names are only unique
+	// within an organization.
+	key := organization + "/" + name
+	if id, ok := m.projects[key]; ok {
+		return id, nil
+	}
+
+	var response struct {
+		Data []struct {
+			ID         string `json:"id"`
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+
+	path := fmt.Sprintf(
+		"organizations/%s/projects?filter[names]=%s",
+		url.QueryEscape(organization), url.QueryEscape(name),
+	)
+	if err := m.apiRequest(ctx, "GET", path, nil, &response); err != nil {
+		return "", fmt.Errorf("Failed to read project %q: %v", name, err)
+	}
+
+	for _, p := range response.Data {
+		if p.Attributes.Name == name {
+			m.projects[key] = p.ID
+			return p.ID, nil
+		}
+	}
+
+	if !m.createProjects {
+		return "", fmt.Errorf("Project %q does not exist (use -create-projects to create it)", name)
+	}
+
+	var project struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+
+	body := map[string]interface{}{
+		"data": map[string]interface{}{
+			"type": "projects",
+			"attributes": map[string]interface{}{
+				"name": name,
+			},
+		},
+	}
+
+	path = fmt.Sprintf("organizations/%s/projects", url.QueryEscape(organization))
+	if err := m.apiRequest(ctx, "POST", path, body, &project); err != nil {
+		return "", fmt.Errorf("Failed to create project %q: %v", name, err)
+	}
+	m.projects[key] = project.Data.ID
+
+	return project.Data.ID, nil
+}
+
+// readWorkspaceNames sets the workspace name of every task that targets an
+// existing workspace by ID, and checks that the workspace belongs to the
+// organization of the task.
+func (m *Migrator) readWorkspaceNames(ctx context.Context, tasks []*Task) error {
+	for _, t := range tasks {
+		if t.workspaceID == "" {
+			continue
+		}
+
+		var response struct {
+			Data struct {
+				Attributes struct {
+					Name string `json:"name"`
+				} `json:"attributes"`
+				Relationships struct {
+					Organization struct {
+						Data struct {
+							ID string `json:"id"`
+						} `json:"data"`
+					} `json:"organization"`
+				} `json:"relationships"`
+			} `json:"data"`
+		}
+
+		path := fmt.Sprintf("workspaces/%s", url.QueryEscape(t.workspaceID))
+		if err := m.apiRequest(ctx, "GET", path, nil, &response); err != nil {
+			return fmt.Errorf("Failed to read workspace %q: %v", t.workspaceID, err)
+		}
+
+		organization := response.Data.Relationships.Organization.Data.ID
+		if organization != t.organization {
+			return fmt.Errorf(
+				"Workspace %q belongs to organization %q instead of %q", t.workspaceID, organization, t.organization,
+			)
+		}
+		t.workspace = response.Data.Attributes.Name
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tfe "github.com/hashicorp/go-tfe"
+)
+
+func TestClassifyCreateError(t *testing.T) {
+	boom := errors.New("boom")
+
+	cases := []struct {
+		err  error
+		want error
+		msg  string
+	}{
+		{
+			err:  tfe.ErrUnauthorized,
+			want: errPermissionDenied,
+			msg:  `permission denied to create workspaces in organization "org": unauthorized`,
+		},
+		{
+			err:  errors.New("403 Forbidden"),
+			want: errPermissionDenied,
+			msg:  `permission denied to create workspaces in organization "org": 403 Forbidden`,
+		},
+		{
+			err:  errors.New("Forbidden\nYou are not authorized to create workspaces"),
+			want: errPermissionDenied,
+		},
+		{
+			err:  tfe.ErrResourceNotFound,
+			want: errOrganizationNotFound,
+			msg:  `organization not found or not accessible: "org"`,
+		},
+		{
+			err:  errors.New("Invalid Attribute\nName has already been taken"),
+			want: errWorkspaceExists,
+			msg:  "workspace already exists: Invalid Attribute\nName has already been taken",
+		},
+		{
+			err:  boom,
+			want: boom,
+			msg:  "boom",
+		},
+	}
+
+	for _, c := range cases {
+		err := classifyCreateError("org", c.err)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%q: expected %q, got: %v", c.err, c.want, err)
+		}
+		if c.msg != "" && err.Error() != c.msg {
+			t.Errorf("%q: expected message %q, got %q", c.err, c.msg, err)
+		}
+	}
+}
+
+func TestCreateWorkspaceClassified(t *testing.T) {
+	cases := []struct {
+		err  error
+		want error
+	}{
+		{tfe.ErrUnauthorized, errPermissionDenied},
+		{errors.New("403 Forbidden"), errPermissionDenied},
+		{tfe.ErrResourceNotFound, errOrganizationNotFound},
+	}
+
+	for _, c := range cases {
+		m := newFakeTFE(t, &fakeTFE{createErr: c.err})
+		task := &Task{organization: "org", workspace: "app"}
+
+		_, _, err := m.createWorkspace(context.Background(), task)
+		if !errors.Is(err, c.want) {
+			t.Errorf("%q: expected %q, got: %v", c.err, c.want, err)
+		}
+	}
+}
+
+func TestCreateWorkspaceConcurrently(t *testing.T) {
+	cases := []struct {
+		name    string
+		states  []*fakeStateVersion
+		created bool
+	}{
+		// The workspace is migrated by the concurrent run, so it's
+		// left alone.
+		{"migrated", []*fakeStateVersion{{serial: 1}}, false},
+		// The concurrent run didn't upload a state (yet), so the
+		// migration is completed.
+		{"empty", nil, true},
+	}
+
+	for _, c := range cases {
+		f := &fakeTFE{}
+		f.beforeCreate = func() {
+			// The workspace is created after checking it exists.
+			f.workspaces = map[string]*tfe.Workspace{"app": {ID: "ws-other", Name: "app"}}
+			f.states = map[string][]*fakeStateVersion{"ws-other": c.states}
+		}
+		m := newFakeTFE(t, f)
+		task := &Task{organization: "org", workspace: "app"}
+
+		w, created, err := m.createWorkspace(context.Background(), task)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if w.ID != "ws-other" || created != c.created {
+			t.Errorf("%s: expected workspace ws-other (created: %t), got %q (created: %t)", c.name, c.created, w.ID, created)
+		}
+		if len(f.created) != 0 {
+			t.Errorf("%s: expected no workspace to be created", c.name)
+		}
+	}
+}